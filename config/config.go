@@ -6,11 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Diniboy1123/manifesto/internal/cron"
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -32,10 +37,34 @@ type Config struct {
 	AllowSubs bool `json:"allow_subs"`
 	// List of users for authentication (leave empty for no auth)
 	Users []User `json:"users"`
+	// Secret key to sign expiring stream URLs with, which can be used instead of tokens in URLs.
+	// Leave empty to disable signed URLs. Requires Users
+	UrlSigningKey string `json:"url_signing_key"`
+	// Duration signed URLs stay valid for if no other one is requested, including the URLs in channel lists (defaults to 24 hours)
+	SignedUrlTTL JSONDuration `json:"signed_url_ttl"`
+	// Longest duration signed URLs can be valid for (defaults to 7 days). Signed URLs expiring later are rejected
+	SignedUrlMaxTTL JSONDuration `json:"signed_url_max_ttl"`
 	// Duration for caching requests (e.g., "3s")
 	CacheDuration JSONDuration `json:"cache_duration"`
+	// Duration for caching manifests requested to serve MPEG-DASH manifests, HLS playlists and media segments.
+	// Media segments need an up to date timeline to look up chunk durations and the chunks to prefetch.
+	// Falls back to CacheDuration if unset
+	ManifestCacheDuration JSONDuration `json:"manifest_cache_duration"`
+	// Duration for caching media segments. Falls back to CacheDuration if unset
+	SegmentCacheDuration JSONDuration `json:"segment_cache_duration"`
+	// Duration for caching manifests requested to generate init segments.
+	// Only codec and protection data is used from them, which rarely changes. Falls back to CacheDuration if unset
+	InitCacheDuration JSONDuration `json:"init_cache_duration"`
+	// Where to keep cached requests, either "disk" (in SaveDir, default) or "memory".
+	// Changes require a restart
+	CacheBackend string `json:"cache_backend"`
+	// Maximum total size of cached requests for the memory cache backend (e.g., "256MB"), defaults to 256MB.
+	// Changes require a restart
+	CacheMaxSize JSONSize `json:"cache_max_size"`
 	// Path to the log file (if empty, log only to stdout)
 	LogPath string `json:"log_path"`
+	// Format of access log lines, either "logfmt" (default) or "json"
+	LogFormat string `json:"log_format"`
 	// GlobalHeaders is a map of HTTP header names to their values.
 	// Keys represent header names (e.g., "Authorization"), and values represent their corresponding values (e.g., "Bearer token").
 	GlobalHeaders map[string]string `json:"global_headers"`
@@ -49,6 +78,9 @@ type Config struct {
 	// If set to true, the server will return a 204 No Content to any request not made
 	// to an existing path.
 	HideNotFound bool `json:"hide_not_found"`
+	// If set to true, /readyz?probe=true reports the probe result of every channel.
+	// It requires no authentication, so it lists every channel to anyone
+	ReadinessDetails bool `json:"readiness_details"`
 	// HttpProxy is the HTTP proxy to use for outgoing requests
 	HttpProxy string `json:"http_proxy"`
 	// HttpsProxy is the HTTPS proxy to use for outgoing requests
@@ -58,6 +90,18 @@ type Config struct {
 	NoProxy string `json:"no_proxy"`
 	// TlsClientInsecure is a flag to disable TLS verification for outgoing requests and proxy connections.
 	TlsClientInsecure bool `json:"tls_client_insecure"`
+	// Maximum number of segments prefetched at the same time across all channels (defaults to 4).
+	// Changes require a restart
+	PrefetchConcurrency int `json:"prefetch_concurrency"`
+	// Directory to save recordings of live channels to. Unlike SaveDir, it is kept between restarts.
+	// Leave it empty to disable recording. Changes require a restart
+	RecordingsDir string `json:"recordings_dir"`
+	// Recordings scheduled to start automatically, requires RecordingsDir
+	Recordings []RecordingSchedule `json:"recordings"`
+	// Player compatibility profiles, selected by the profile query parameter or by the User-Agent header of requests
+	Profiles []Profile `json:"profiles"`
+	// Compiled user_agent patterns of Profiles, in the same order
+	profileUserAgents []*regexp.Regexp
 }
 
 // Channel represents a single channel configuration
@@ -67,19 +111,131 @@ type Channel struct {
 	// Reserved for future use to specify the source type of the channel.
 	// Currently, it is unused and should be set to "ism" as a placeholder.
 	SourceType string `json:"source_type"`
-	// Reserved for future use to specify the destination type of the channel.
-	// Currently unused, but intended for future support of different output formats. Set it to "mpd" for now.
+	// Destination type of the channel, selects which output formats are served.
+	// Set it to "mpd" for MPEG-DASH, "m3u8" for HLS or leave it empty to serve both.
 	DestinationType string `json:"destination_type"`
-	// Friendly name for the channel, might be used in the future for display purposes
+	// Friendly name for the channel, shown in the channel list and M3U playlist. Falls back to Id if unset
 	Name string `json:"name"`
+	// EPG ID of the channel, used as tvg-id in the M3U playlist. Falls back to Id if unset
+	TvgId string `json:"tvg_id"`
 	// Manifest URL to fetch the stream from
 	Url string `json:"url"`
 	// If channel is encrypted, this is a list of keys to use for decryption, if left empty, no decryption will be attempted
 	Keys []string `json:"keys"`
+	// Key IDs of the tracks in hex format, for providers encrypting tracks with different keys without telling
+	// which one in the segments. Maps stream index names (like "video" or "audio_deu") or representation IDs
+	// (like "video_3", for quality levels with a key of their own) to key IDs. Optional
+	TrackKeyIds map[string]string `json:"track_key_ids"`
+	// If set to true, segments of channels with keys are served encrypted and the keys are handed out to
+	// players via the W3C ClearKey system instead of decrypting server-side
+	ClearKey bool `json:"clearkey"`
+	// Whether to advertise Widevine next to PlayReady for encrypted channels that are not decrypted.
+	// The Widevine PSSH is synthesized from the PlayReady key IDs, so the license server must know the same keys.
+	Widevine bool `json:"widevine"`
+	// License server URL to forward license challenges to. If set, the channel's license proxy endpoint is enabled
+	// and advertised as the PlayReady license server in MPEG-DASH manifests instead of the provider's one. Leave empty to disable
+	LicenseUrl string `json:"license_url"`
+	// HTTP headers to add to license requests forwarded to LicenseUrl (e.g. cookies or authorization headers).
+	// Optional field
+	LicenseHeaders map[string]string `json:"license_headers"`
+	// Number of upcoming segments to fetch in the background after serving a segment of a live channel.
+	// Set to 0 to disable
+	Prefetch int `json:"prefetch"`
+	// Overrides manifest_cache_duration for this channel if set
+	ManifestCacheDuration JSONDuration `json:"manifest_cache_duration"`
+	// Overrides segment_cache_duration for this channel if set
+	SegmentCacheDuration JSONDuration `json:"segment_cache_duration"`
+	// Overrides init_cache_duration for this channel if set
+	InitCacheDuration JSONDuration `json:"init_cache_duration"`
 	// Value to advertise in MPEG-DASH suggestedPresentationDelay attribute
 	// useful for live streams where chunks aren't yet available.
 	// Set to 0 to disable
 	Delay JSONDuration `json:"delay"`
+	// Length of the DVR window advertised for live channels. Chunks that dropped out of the source manifest
+	// are kept in the timeline until they are older than this, so players can rewind further than the source allows.
+	// The timeline is only kept in memory while the channel is watched, so it starts over after a restart.
+	// Set to 0 to use the source's DVR window
+	DvrWindow JSONDuration `json:"dvr_window"`
+	// If set to true, MPEG-DASH presentations start at zero via presentationTimeOffset instead of the absolute times
+	// of the Smooth timeline, and live channels get an availabilityStartTime derived from the wall clock
+	NormalizeTime bool `json:"normalize_time"`
+	// If set to true, the tfdt box of every segment is rewritten with the time from the manifest timeline,
+	// and sample durations not adding up to the chunk duration are corrected (or logged if they are too far off)
+	RewriteTfdt bool `json:"rewrite_tfdt"`
+}
+
+const (
+	// DestinationMpd is the destination type for MPEG-DASH manifests
+	DestinationMpd = "mpd"
+	// DestinationM3u8 is the destination type for HLS playlists
+	DestinationM3u8 = "m3u8"
+)
+
+const (
+	// LogFormatLogfmt writes access log lines as key=value pairs
+	LogFormatLogfmt = "logfmt"
+	// LogFormatJson writes access log lines as JSON objects
+	LogFormatJson = "json"
+)
+
+// SignedUrlPrefix is the first path segment of signed stream URLs, in place of a token
+const SignedUrlPrefix = "s"
+
+// minUrlSigningKeyLength is the minimum length of url_signing_key, shorter keys are too easy to guess
+const minUrlSigningKeyLength = 32
+
+// validProfileName matches the names profiles can have, as they are used in URLs
+var validProfileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// RecordingsGroup is the group name recordings are served under, in place of a channel group
+const RecordingsGroup = "recordings"
+
+const (
+	// CacheBackendDisk stores cached requests as files in SaveDir
+	CacheBackendDisk = "disk"
+	// CacheBackendMemory stores cached requests in memory
+	CacheBackendMemory = "memory"
+)
+
+// RecordingSchedule represents a recording started automatically, either once or recurring
+type RecordingSchedule struct {
+	// Channel to record as group/channel
+	Channel string `json:"channel"`
+	// IDs of the representations to record (e.g. "video_0"), all of them if empty
+	Representations []string `json:"representations"`
+	// Cron expression (minute hour day-of-month month day-of-week) of the start times of a recurring recording.
+	// Either Cron or Start must be set
+	Cron string `json:"cron"`
+	// Start time of a one-off recording
+	Start time.Time `json:"start"`
+	// End time of a one-off recording. Either End or Duration must be set for one-off recordings
+	End time.Time `json:"end"`
+	// Duration of the recording, required for recurring recordings
+	Duration JSONDuration `json:"duration"`
+	// Time zone cron expressions are evaluated in (e.g. "Europe/Berlin"), local time if empty
+	TimeZone string `json:"time_zone"`
+}
+
+// Profile represents a set of player compatibility tweaks applied to requests of matching players.
+// Options left unset keep the default behavior
+type Profile struct {
+	// Name of the profile, which can be selected with the profile query parameter (e.g. "?profile=vlc")
+	Name string `json:"name"`
+	// Regular expression matched against the User-Agent header of requests without the profile query parameter.
+	// The first matching profile is used. Leave it empty to only select the profile via the query parameter
+	UserAgent string `json:"user_agent"`
+	// Overrides AllowSubs for matching requests
+	AllowSubs *bool `json:"allow_subs"`
+	// Whether to advertise the delay of channels as suggestedPresentationDelay, defaults to true
+	Delay *bool `json:"delay"`
+	// Whether to add tfdt boxes to segments without one, defaults to true. Channels with rewrite_tfdt always get them
+	Tfdt *bool `json:"tfdt"`
+	// Whether to add sidx boxes to subtitle segments without one, defaults to true
+	SubtitleSidx *bool `json:"subtitle_sidx"`
+	// Whether to remove sdtp boxes from video segments, defaults to true
+	StripSdtp *bool `json:"strip_sdtp"`
+	// Whether to replace the trun samples of subtitle segments with default sample values in tfhd, defaults to true
+	SubtitleTrun *bool `json:"subtitle_trun"`
 }
 
 // Key represents a keyid and key used for decryption
@@ -102,11 +258,16 @@ type User struct {
 	// Token is the token used for authentication
 	// Set it to whatever you like, but make sure it is unique and not guessable
 	Token string `json:"token"`
+	// Admin allows the user to manage recordings via the admin endpoints
+	Admin bool `json:"admin"`
 }
 
 // JSONDuration is a custom type for smarter JSON unmarshalling of time.Duration
 type JSONDuration time.Duration
 
+// JSONSize is a custom type for smarter JSON unmarshalling of sizes in bytes
+type JSONSize int64
+
 // TLSDomainConfig represents a TLS domain configuration
 type TLSDomainConfig struct {
 	// Domain is the domain name for the TLS certificate
@@ -126,6 +287,10 @@ var (
 	configPath string
 	// configMutex is used to synchronize access to the config
 	configMutex sync.RWMutex
+	// reloadListeners holds the channels returned by NotifyReload
+	reloadListeners []chan struct{}
+	// reloadListenersMutex protects reloadListeners
+	reloadListenersMutex sync.Mutex
 )
 
 // LoadConfig loads the configuration from the specified path
@@ -168,13 +333,44 @@ func reloadConfig() error {
 			appConfig.channelMap[key] = ch
 		}
 	}
+	appConfig.profileUserAgents = make([]*regexp.Regexp, len(appConfig.Profiles))
+	for i, profile := range appConfig.Profiles {
+		if profile.UserAgent != "" {
+			// already validated
+			appConfig.profileUserAgents[i] = regexp.MustCompile(profile.UserAgent)
+		}
+	}
 	ConfigLoaded = true
 	configMutex.Unlock()
 
 	log.Println("Config reloaded successfully")
+
+	reloadListenersMutex.Lock()
+	for _, listener := range reloadListeners {
+		// listeners only need to know that something changed, so pending notifications aren't stacked
+		select {
+		case listener <- struct{}{}:
+		default:
+		}
+	}
+	reloadListenersMutex.Unlock()
+
 	return nil
 }
 
+// NotifyReload returns a channel that receives a value after the config was reloaded,
+// so long running goroutines can pick up changes. Reloads happening before the value is
+// received are coalesced into a single notification.
+func NotifyReload() <-chan struct{} {
+	listener := make(chan struct{}, 1)
+
+	reloadListenersMutex.Lock()
+	reloadListeners = append(reloadListeners, listener)
+	reloadListenersMutex.Unlock()
+
+	return listener
+}
+
 // WatchConfig sets up a file watcher to monitor changes to the config file
 // and reloads the config when changes are detected
 func WatchConfig() {
@@ -234,6 +430,103 @@ func validateConfig(config Config) error {
 	if config.CacheDuration.Duration() <= 0 {
 		return fmt.Errorf("cache_duration must be greater than 0")
 	}
+	if config.ManifestCacheDuration < 0 || config.SegmentCacheDuration < 0 || config.InitCacheDuration < 0 {
+		return fmt.Errorf("cache durations cannot be negative")
+	}
+	switch config.CacheBackend {
+	case "", CacheBackendDisk, CacheBackendMemory:
+	default:
+		return fmt.Errorf("unsupported cache_backend %q, use disk or memory", config.CacheBackend)
+	}
+	if config.CacheMaxSize < 0 {
+		return fmt.Errorf("cache_max_size cannot be negative")
+	}
+	if config.SignedUrlTTL < 0 || config.SignedUrlMaxTTL < 0 {
+		return fmt.Errorf("signed URL durations cannot be negative")
+	}
+	if config.SignedUrlMaxTTL > 0 && config.SignedUrlTTL > config.SignedUrlMaxTTL {
+		return fmt.Errorf("signed_url_ttl cannot be longer than signed_url_max_ttl")
+	}
+	if config.UrlSigningKey != "" {
+		if len(config.Users) == 0 {
+			return fmt.Errorf("url_signing_key is set, but no users are configured")
+		}
+		if len(config.UrlSigningKey) < minUrlSigningKeyLength {
+			return fmt.Errorf("url_signing_key must be at least %d characters long", minUrlSigningKeyLength)
+		}
+		usernames := make(map[string]bool)
+		for _, user := range config.Users {
+			// signed URLs are served under /s/, which would shadow the URLs of this token
+			if user.Token == SignedUrlPrefix {
+				return fmt.Errorf("user %s can't have the token %q while url_signing_key is set", user.Username, SignedUrlPrefix)
+			}
+			// signed URLs identify users by name
+			if user.Username == "" || usernames[user.Username] {
+				return fmt.Errorf("usernames must be unique and not empty while url_signing_key is set")
+			}
+			usernames[user.Username] = true
+		}
+	}
+	switch config.LogFormat {
+	case "", LogFormatLogfmt, LogFormatJson:
+	default:
+		return fmt.Errorf("unsupported log_format %q, use logfmt or json", config.LogFormat)
+	}
+	if _, found := config.Channels[RecordingsGroup]; found && config.RecordingsDir != "" {
+		return fmt.Errorf("the channel group %q is reserved for recordings", RecordingsGroup)
+	}
+	if len(config.Recordings) > 0 && config.RecordingsDir == "" {
+		return fmt.Errorf("recordings are scheduled, but recordings_dir is empty")
+	}
+	profileNames := make(map[string]bool)
+	for _, profile := range config.Profiles {
+		if !validProfileName.MatchString(profile.Name) {
+			return fmt.Errorf("profile name %q must only contain letters, digits, dashes and underscores", profile.Name)
+		}
+		if profileNames[profile.Name] {
+			return fmt.Errorf("profile %s is defined more than once", profile.Name)
+		}
+		profileNames[profile.Name] = true
+		if _, err := regexp.Compile(profile.UserAgent); err != nil {
+			return fmt.Errorf("profile %s has an invalid user_agent: %v", profile.Name, err)
+		}
+	}
+	for i, schedule := range config.Recordings {
+		if err := validateRecordingSchedule(config, schedule); err != nil {
+			return fmt.Errorf("recording %d: %v", i, err)
+		}
+	}
+	for groupName, channelList := range config.Channels {
+		for _, ch := range channelList {
+			switch ch.DestinationType {
+			case "", DestinationMpd, DestinationM3u8:
+			default:
+				return fmt.Errorf("channel %s/%s has an unsupported destination_type %q", groupName, ch.Id, ch.DestinationType)
+			}
+			if ch.ManifestCacheDuration < 0 || ch.SegmentCacheDuration < 0 || ch.InitCacheDuration < 0 {
+				return fmt.Errorf("channel %s/%s has a negative cache duration", groupName, ch.Id)
+			}
+			if ch.DvrWindow < 0 {
+				return fmt.Errorf("channel %s/%s has a negative dvr_window", groupName, ch.Id)
+			}
+			if ch.Prefetch < 0 {
+				return fmt.Errorf("channel %s/%s has a negative prefetch count", groupName, ch.Id)
+			}
+			if ch.ClearKey && len(ch.Keys) == 0 {
+				return fmt.Errorf("channel %s/%s has clearkey enabled, but no keys", groupName, ch.Id)
+			}
+			for track, keyId := range ch.TrackKeyIds {
+				if parsed, err := hex.DecodeString(keyId); err != nil || len(parsed) != 16 {
+					return fmt.Errorf("channel %s/%s has an invalid key ID for track %s, must be a 16-byte hex string", groupName, ch.Id, track)
+				}
+			}
+			if ch.LicenseUrl != "" {
+				if u, err := url.Parse(ch.LicenseUrl); err != nil || u.Scheme == "" || u.Host == "" {
+					return fmt.Errorf("channel %s/%s has an invalid license_url %q", groupName, ch.Id, ch.LicenseUrl)
+				}
+			}
+		}
+	}
 	if len(config.TLSDomainMap) > 0 || config.HttpsPort > 0 {
 		if config.HttpsPort > 0 && len(config.TLSDomainMap) == 0 {
 			return fmt.Errorf("https_port is set, but tls_domain_map must also be provided")
@@ -260,6 +553,51 @@ func validateConfig(config Config) error {
 	return nil
 }
 
+// validateRecordingSchedule checks if a scheduled recording refers to an existing channel
+// and has exactly one valid way to determine its start and end times
+func validateRecordingSchedule(config Config, schedule RecordingSchedule) error {
+	group, channelId, found := strings.Cut(schedule.Channel, "/")
+	if !found {
+		return fmt.Errorf("channel must be given as group/channel")
+	}
+	if !slices.ContainsFunc(config.Channels[group], func(ch Channel) bool { return ch.Id == channelId }) {
+		return fmt.Errorf("channel %s not found", schedule.Channel)
+	}
+	if schedule.Duration < 0 {
+		return fmt.Errorf("duration cannot be negative")
+	}
+	if schedule.TimeZone != "" {
+		if _, err := time.LoadLocation(schedule.TimeZone); err != nil {
+			return fmt.Errorf("invalid time_zone %q: %v", schedule.TimeZone, err)
+		}
+	}
+
+	switch {
+	case schedule.Cron != "" && !schedule.Start.IsZero():
+		return fmt.Errorf("only one of cron and start can be set")
+	case schedule.Cron != "":
+		if _, err := cron.Parse(schedule.Cron); err != nil {
+			return err
+		}
+		if schedule.Duration == 0 {
+			return fmt.Errorf("recurring recordings require a duration")
+		}
+		if !schedule.End.IsZero() {
+			return fmt.Errorf("recurring recordings can't have an end")
+		}
+	case !schedule.Start.IsZero():
+		if schedule.End.IsZero() == (schedule.Duration == 0) {
+			return fmt.Errorf("one-off recordings require either an end or a duration")
+		}
+		if !schedule.End.IsZero() && !schedule.End.After(schedule.Start) {
+			return fmt.Errorf("end must be after start")
+		}
+	default:
+		return fmt.Errorf("either cron or start must be set")
+	}
+	return nil
+}
+
 // retryReloadConfig attempts to reload the config a specified number of times with a delay between attempts.
 // This is a hacky cross-platform to handle partial writes of the config file.
 func retryReloadConfig(retries int, delay time.Duration) {
@@ -297,6 +635,63 @@ func (d JSONDuration) Duration() time.Duration {
 	return time.Duration(d)
 }
 
+// sizeUnits maps the supported size suffixes to their multipliers
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	// longer suffixes first, so "MB" isn't matched as "B"
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"B", 1},
+}
+
+// UnmarshalJSON parses a size from either a number of bytes or a string
+// with a KB, MB or GB suffix (e.g., "256MB"). Units are powers of 1024.
+func (s *JSONSize) UnmarshalJSON(b []byte) error {
+	var bytesCount int64
+	if err := json.Unmarshal(b, &bytesCount); err == nil {
+		*s = JSONSize(bytesCount)
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	str = strings.ToUpper(strings.TrimSpace(str))
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(str, unit.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q: %v", string(b), err)
+	}
+	*s = JSONSize(value * multiplier)
+	return nil
+}
+
+// GetTrackKeyId returns the key ID configured for a track in TrackKeyIds, looked up by the representation ID
+// first and by the stream index name second. It returns nil if no key ID is configured for the track.
+func (c Channel) GetTrackKeyId(streamIndexName, representationId string) []byte {
+	for _, track := range []string{representationId, streamIndexName} {
+		if keyId, found := c.TrackKeyIds[track]; found {
+			// already validated
+			parsed, _ := hex.DecodeString(keyId)
+			return parsed
+		}
+	}
+	return nil
+}
+
 // GetKey retrieves a key by its keyId from the channel's keys
 func (c Channel) GetKey(keyID []byte) ([]byte, error) {
 	for _, rawKey := range c.Keys {
@@ -311,6 +706,62 @@ func (c Channel) GetKey(keyID []byte) ([]byte, error) {
 	return nil, fmt.Errorf("key not found")
 }
 
+// ManifestTTL returns how long manifests of the given channel are cached
+// when serving MPEG-DASH manifests, HLS playlists and media segments.
+func (c Config) ManifestTTL(channel Channel) time.Duration {
+	return c.resolveCacheDuration(channel.ManifestCacheDuration, c.ManifestCacheDuration)
+}
+
+// SegmentTTL returns how long media segments of the given channel are cached.
+func (c Config) SegmentTTL(channel Channel) time.Duration {
+	return c.resolveCacheDuration(channel.SegmentCacheDuration, c.SegmentCacheDuration)
+}
+
+// InitTTL returns how long manifests of the given channel are cached
+// when generating init segments.
+func (c Config) InitTTL(channel Channel) time.Duration {
+	return c.resolveCacheDuration(channel.InitCacheDuration, c.InitCacheDuration)
+}
+
+// resolveCacheDuration returns the first set duration of the channel override,
+// the global value and CacheDuration.
+func (c Config) resolveCacheDuration(channelDuration, globalDuration JSONDuration) time.Duration {
+	if channelDuration > 0 {
+		return channelDuration.Duration()
+	}
+	if globalDuration > 0 {
+		return globalDuration.Duration()
+	}
+	return c.CacheDuration.Duration()
+}
+
+// GetKeys parses and returns every key configured for the channel.
+//
+// If any of the keys is malformed, it returns an error.
+func (c Channel) GetKeys() ([]Key, error) {
+	keys := make([]Key, 0, len(c.Keys))
+	for _, rawKey := range c.Keys {
+		keyId, key, err := parseKey(rawKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, Key{KeyID: keyId, Key: key})
+	}
+	return keys, nil
+}
+
+// ShouldDecrypt reports whether segments of the channel should be decrypted server-side.
+// This is the case if keys are configured, unless they are handed out to players in ClearKey mode.
+func (c Channel) ShouldDecrypt() bool {
+	return len(c.Keys) > 0 && !c.ClearKey
+}
+
+// ServesDestination reports whether the channel should be served in the given destination format.
+// Channels without a destination type are served in every format.
+func (c Channel) ServesDestination(destinationType string) bool {
+	return c.DestinationType == "" || c.DestinationType == destinationType
+}
+
 // parseKey parses a key string in the format "keyId:keyData"
 // and returns the key ID and key data as byte slices
 func parseKey(key string) (keyID []byte, keyData []byte, err error) {
@@ -342,3 +793,29 @@ func (c Config) GetChannel(group, id string) (Channel, bool) {
 	channel, exists := c.channelMap[key]
 	return channel, exists
 }
+
+// GetProfile retrieves the profile to serve a request with. If name is not empty, the profile with that name is returned,
+// otherwise the first profile whose user_agent matches userAgent.
+// It returns false if no profile applies
+func (c Config) GetProfile(name, userAgent string) (Profile, bool) {
+	for i, profile := range c.Profiles {
+		if name != "" {
+			if profile.Name == name {
+				return profile, true
+			}
+			continue
+		}
+		if i < len(c.profileUserAgents) && c.profileUserAgents[i] != nil && c.profileUserAgents[i].MatchString(userAgent) {
+			return profile, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Option returns the value of a profile option, or fallback if the profile leaves it unset
+func (p Profile) Option(value *bool, fallback bool) bool {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+	"github.com/Diniboy1123/manifesto/models"
+	"github.com/Diniboy1123/manifesto/transformers"
+)
+
+// runConvert implements the convert subcommand, which converts a Smooth manifest to an MPEG-DASH manifest
+// without starting the server. No config file is needed, the channel options are taken from flags.
+func runConvert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	in := flags.String("in", "", "Smooth manifest to convert, either a file path or an http(s) URL")
+	out := flags.String("out", "-", "Path to write the MPD to, - for stdout")
+	baseUrl := flags.String("base-url", "", "BaseURL to add to the MPD, e.g. the channel URL on a manifesto instance like http://localhost:8080/stream/<group>/<channel>/")
+	name := flags.String("name", "", "Channel name to use as the title of the MPD")
+	allowSubs := flags.Bool("allow-subs", true, "Whether to include subtitle tracks")
+	widevine := flags.Bool("widevine", false, "Whether to advertise Widevine for encrypted manifests")
+	delay := flags.Duration("delay", 0, "suggestedPresentationDelay to advertise for live manifests")
+	flags.Parse(args)
+
+	if *in == "" {
+		flags.Usage()
+		return errors.New("-in is required")
+	}
+
+	source, err := openSource(*in)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	ismManifest, err := models.NewSmoothStream(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse manifest: %v", err)
+	}
+
+	channel := config.Channel{
+		Name:     *name,
+		Url:      *in,
+		Widevine: *widevine,
+		Delay:    config.JSONDuration(*delay),
+	}
+
+	// without keys, encrypted manifests keep their DRM info like they would on the server
+	mpd, err := transformers.SmoothToDashManifest(ismManifest, false, *allowSubs, channel, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to transform manifest: %v", err)
+	}
+	if *baseUrl != "" {
+		mpd.BaseURL = []*models.BaseURL{{Value: *baseUrl}}
+	}
+
+	mpdXML, err := mpd.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+
+	if *out == "-" {
+		_, err = os.Stdout.Write(mpdXML)
+		return err
+	}
+	return os.WriteFile(*out, mpdXML, 0644)
+}
+
+// openSource opens a manifest from a file path or an http(s) URL.
+// URLs are fetched without caching, with the default user agent.
+func openSource(source string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.Open(source)
+	}
+
+	resp, err := utils.DoUncachedRequest(http.MethodGet, source, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch manifest: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
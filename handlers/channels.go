@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+)
+
+// channelListGroup is a group of channels in the channel list.
+type channelListGroup struct {
+	// Name is the name of the group, as used in the channel URLs
+	Name string `json:"name"`
+	// Channels holds the channels of the group in config order
+	Channels []channelListEntry `json:"channels"`
+}
+
+// channelListEntry is a single channel in the channel list.
+type channelListEntry struct {
+	// Id is the ID of the channel, as used in the channel URLs
+	Id string `json:"id"`
+	// Name is the display name of the channel, falls back to the ID if not configured
+	Name string `json:"name"`
+	// TvgId is the EPG ID of the channel, falls back to the ID if not configured
+	TvgId string `json:"tvg_id"`
+	// Url is the manifest URL players should use, based on the channel's destination type
+	Url string `json:"url"`
+	// DashUrl is the MPEG-DASH manifest URL, if the channel is served as MPEG-DASH
+	DashUrl string `json:"dash_url,omitempty"`
+	// HlsUrl is the HLS master playlist URL, if the channel is served as HLS
+	HlsUrl string `json:"hls_url,omitempty"`
+}
+
+// ChannelListHandler lists every configured channel grouped by group name as JSON.
+// Groups are sorted by name, channels keep their order from the config.
+//
+// The manifest URLs are absolute and include the token of the requesting user if authentication is enabled,
+// so they can be handed to players as-is. If URL signing is configured, they are signed URLs instead, which keep
+// the token out of players and expire after utils.SignedUrlTTL.
+func ChannelListHandler(w http.ResponseWriter, r *http.Request) {
+	groups := getChannelList(r)
+
+	body, err := json.Marshal(struct {
+		Groups []channelListGroup `json:"groups"`
+	}{groups})
+	if err != nil {
+		http.Error(w, "Error encoding channel list", http.StatusInternalServerError)
+		return
+	}
+
+	reqStartTime := r.Context().Value("reqStartTime").(time.Time)
+	reqTook := time.Since(reqStartTime)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Server-Timing", fmt.Sprintf("total;dur=%.3f", reqTook.Seconds()*1000))
+	w.WriteHeader(http.StatusOK)
+
+	w.Write(body)
+}
+
+// M3uPlaylistHandler lists every configured channel as an extended M3U playlist, which can be imported
+// into IPTV players like Kodi or TiviMate. Every channel gets an #EXTINF entry with its group-title,
+// tvg-id and tvg-name attributes.
+//
+// Channels are linked by the manifest URL matching their destination type, MPEG-DASH if they are served in both formats.
+// Setting the "format" query parameter to "mpd" or "m3u8" prefers that format for channels serving both.
+func M3uPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != config.DestinationMpd && format != config.DestinationM3u8 {
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	playlist := renderM3uPlaylist(getChannelList(r), format)
+
+	reqStartTime := r.Context().Value("reqStartTime").(time.Time)
+	reqTook := time.Since(reqStartTime)
+
+	w.Header().Set("Content-Type", "audio/x-mpegurl")
+	w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
+	w.Header().Set("Server-Timing", fmt.Sprintf("total;dur=%.3f", reqTook.Seconds()*1000))
+	w.WriteHeader(http.StatusOK)
+
+	w.Write([]byte(playlist))
+}
+
+// getChannelList builds the channel list from the current config with absolute URLs for the given request.
+// The URLs are signed for the requesting user if URL signing is configured, see SignUrlHandler.
+func getChannelList(r *http.Request) []channelListGroup {
+	cfg := config.Get()
+
+	user, _ := r.Context().Value("user").(*config.User)
+	signUrls := cfg.UrlSigningKey != "" && user != nil
+	// signatures have a precision of a second
+	expires := time.Now().Add(utils.SignedUrlTTL()).Truncate(time.Second)
+
+	baseUrl := getBaseUrl(r)
+	if token := r.PathValue("token"); token != "" && !signUrls {
+		baseUrl += "/" + url.PathEscape(token)
+	}
+
+	groupNames := make([]string, 0, len(cfg.Channels))
+	for groupName := range cfg.Channels {
+		groupNames = append(groupNames, groupName)
+	}
+	slices.Sort(groupNames)
+
+	groups := make([]channelListGroup, 0, len(groupNames))
+	for _, groupName := range groupNames {
+		group := channelListGroup{
+			Name:     groupName,
+			Channels: make([]channelListEntry, 0, len(cfg.Channels[groupName])),
+		}
+
+		for _, channel := range cfg.Channels[groupName] {
+			channelUrl := baseUrl
+			if signUrls {
+				channelUrl += utils.SignStreamPath(cfg.UrlSigningKey, user.Username, groupName+"/"+channel.Id, expires)
+			}
+			channelUrl += "/stream/" + url.PathEscape(groupName) + "/" + url.PathEscape(channel.Id) + "/"
+
+			entry := channelListEntry{
+				Id:    channel.Id,
+				Name:  channel.Name,
+				TvgId: channel.TvgId,
+			}
+			if entry.Name == "" {
+				entry.Name = channel.Id
+			}
+			if entry.TvgId == "" {
+				entry.TvgId = channel.Id
+			}
+			if channel.ServesDestination(config.DestinationMpd) {
+				entry.DashUrl = channelUrl + "manifest.mpd"
+			}
+			if channel.ServesDestination(config.DestinationM3u8) {
+				entry.HlsUrl = channelUrl + "master.m3u8"
+			}
+
+			entry.Url = entry.DashUrl
+			if entry.Url == "" {
+				entry.Url = entry.HlsUrl
+			}
+
+			group.Channels = append(group.Channels, entry)
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// renderM3uPlaylist renders the channel list as an extended M3U playlist.
+// If format is set, channels serving that format are linked by its URL instead of their default one.
+func renderM3uPlaylist(groups []channelListGroup, format string) string {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+
+	for _, group := range groups {
+		for _, channel := range group.Channels {
+			channelUrl := channel.Url
+			switch {
+			case format == config.DestinationMpd && channel.DashUrl != "":
+				channelUrl = channel.DashUrl
+			case format == config.DestinationM3u8 && channel.HlsUrl != "":
+				channelUrl = channel.HlsUrl
+			}
+
+			fmt.Fprintf(&sb, "#EXTINF:-1 tvg-id=\"%s\" tvg-name=\"%s\" group-title=\"%s\",%s\n%s\n",
+				m3uAttribute(channel.TvgId),
+				m3uAttribute(channel.Name),
+				m3uAttribute(group.Name),
+				m3uTitle(channel.Name),
+				channelUrl,
+			)
+		}
+	}
+
+	return sb.String()
+}
+
+// m3uAttribute makes a value safe to use as a quoted #EXTINF attribute.
+// M3U has no escaping, so quotes and line breaks are dropped.
+func m3uAttribute(value string) string {
+	return strings.NewReplacer("\"", "", "\r", "", "\n", "").Replace(value)
+}
+
+// m3uTitle makes a value safe to use as the title of an #EXTINF entry.
+func m3uTitle(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+)
+
+func TestRenderM3uPlaylist(t *testing.T) {
+	groups := []channelListGroup{
+		{
+			Name: "sports",
+			Channels: []channelListEntry{
+				{
+					Id:      "one",
+					Name:    "Sport \"One\"",
+					TvgId:   "one.hu",
+					Url:     "http://localhost/stream/sports/one/manifest.mpd",
+					DashUrl: "http://localhost/stream/sports/one/manifest.mpd",
+					HlsUrl:  "http://localhost/stream/sports/one/master.m3u8",
+				},
+				{
+					Id:     "two",
+					Name:   "two",
+					TvgId:  "two",
+					Url:    "http://localhost/stream/sports/two/master.m3u8",
+					HlsUrl: "http://localhost/stream/sports/two/master.m3u8",
+				},
+			},
+		},
+	}
+
+	expected := "#EXTM3U\n" +
+		"#EXTINF:-1 tvg-id=\"one.hu\" tvg-name=\"Sport One\" group-title=\"sports\",Sport \"One\"\n" +
+		"http://localhost/stream/sports/one/manifest.mpd\n" +
+		"#EXTINF:-1 tvg-id=\"two\" tvg-name=\"two\" group-title=\"sports\",two\n" +
+		"http://localhost/stream/sports/two/master.m3u8\n"
+	if playlist := renderM3uPlaylist(groups, ""); playlist != expected {
+		t.Errorf("Unexpected playlist:\n%s", playlist)
+	}
+
+	// channels serving the preferred format are linked by it, the others keep their default
+	expected = "#EXTM3U\n" +
+		"#EXTINF:-1 tvg-id=\"one.hu\" tvg-name=\"Sport One\" group-title=\"sports\",Sport \"One\"\n" +
+		"http://localhost/stream/sports/one/master.m3u8\n" +
+		"#EXTINF:-1 tvg-id=\"two\" tvg-name=\"two\" group-title=\"sports\",two\n" +
+		"http://localhost/stream/sports/two/master.m3u8\n"
+	if playlist := renderM3uPlaylist(groups, "m3u8"); playlist != expected {
+		t.Errorf("Unexpected playlist with format m3u8:\n%s", playlist)
+	}
+}
+
+func TestGetChannelListSignsUrls(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	configData := `{"http_port": 8080, "bind_addr": "127.0.0.1", "save_dir": "` + dir + `", "cache_duration": "1m",
+		"users": [{"username": "bob", "token": "secrettoken"}], "url_signing_key": "0123456789abcdef0123456789abcdef",
+		"signed_url_ttl": "2h", "channels": {"g": [{"id": "live", "url": "http://localhost/live/Manifest"}]}}`
+	if err := os.WriteFile(configPath, []byte(configData), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := config.LoadConfig(configPath); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/secrettoken/playlist.m3u", nil)
+	r.SetPathValue("token", "secrettoken")
+	r = r.WithContext(context.WithValue(r.Context(), "user", &config.Get().Users[0]))
+
+	groups := getChannelList(r)
+	if len(groups) != 1 || len(groups[0].Channels) != 1 {
+		t.Fatalf("Unexpected channel list %+v", groups)
+	}
+	channelUrl := groups[0].Channels[0].Url
+	if strings.Contains(channelUrl, "secrettoken") || !strings.HasPrefix(channelUrl, "http://example.com/s/bob/") {
+		t.Fatalf("Expected a signed URL without the token, got %s", channelUrl)
+	}
+
+	// the URL must be accepted for the channel and expire after signed_url_ttl
+	parts := strings.Split(strings.TrimPrefix(channelUrl, "http://example.com/"), "/")
+	if err := utils.VerifyStreamSignature(config.Get().UrlSigningKey, "bob", "g/live", parts[2], parts[3], time.Now(), utils.MaxSignedUrlTTL()); err != nil {
+		t.Fatalf("Expected a valid signature, got %v", err)
+	}
+	if err := utils.VerifyStreamSignature(config.Get().UrlSigningKey, "bob", "g/live", parts[2], parts[3], time.Now().Add(2*time.Hour), utils.MaxSignedUrlTTL()); err == nil {
+		t.Fatalf("Expected the signature to expire after signed_url_ttl")
+	}
+}
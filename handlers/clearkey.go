@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+)
+
+// clearKeyRequest is the license request sent by ClearKey clients, as defined by the W3C EME specification.
+type clearKeyRequest struct {
+	// Kids holds the requested key IDs in unpadded base64url encoding
+	Kids []string `json:"kids"`
+	// Type is the session type, usually "temporary"
+	Type string `json:"type"`
+}
+
+// jsonWebKey is a symmetric key in JSON Web Key format.
+type jsonWebKey struct {
+	// Kty is the key type, always "oct"
+	Kty string `json:"kty"`
+	// Kid is the key ID in unpadded base64url encoding
+	Kid string `json:"kid"`
+	// K is the key in unpadded base64url encoding
+	K string `json:"k"`
+}
+
+// jsonWebKeySet is the license response expected by ClearKey clients.
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+	Type string       `json:"type"`
+}
+
+// ClearKeyHandler serves the keys of a channel as a JSON Web Key set, so players can decrypt
+// the segments themselves via the W3C ClearKey system.
+//
+// ClearKey clients send a POST request with the key IDs they need. Only those keys are returned.
+// A GET request returns every key of the channel, which is handy for players that are configured
+// with a static key URL.
+//
+// The handler expects the channel information to be present in the request context.
+// If the channel doesn't have ClearKey enabled, it returns a 404 Not Found error.
+func ClearKeyHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := r.Context().Value("channel").(config.Channel)
+	if !ok {
+		http.Error(w, "Channel not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if !channel.ClearKey {
+		http.Error(w, "ClearKey not enabled", http.StatusNotFound)
+		return
+	}
+
+	var request clearKeyRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLicenseChallengeSize)).Decode(&request); err != nil {
+			http.Error(w, "Invalid ClearKey request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var requestedKeyIds [][]byte
+	for _, kid := range request.Kids {
+		keyId, err := base64.RawURLEncoding.DecodeString(kid)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid key ID %q", kid), http.StatusBadRequest)
+			return
+		}
+		requestedKeyIds = append(requestedKeyIds, keyId)
+	}
+
+	keys, err := channel.GetKeys()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching keys: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	keySet := jsonWebKeySet{
+		Keys: []jsonWebKey{},
+		Type: "temporary",
+	}
+	if request.Type != "" {
+		keySet.Type = request.Type
+	}
+	for _, key := range keys {
+		if r.Method == http.MethodPost && !slices.ContainsFunc(requestedKeyIds, func(id []byte) bool { return bytes.Equal(id, key.KeyID) }) {
+			continue
+		}
+		keySet.Keys = append(keySet.Keys, jsonWebKey{
+			Kty: "oct",
+			Kid: base64.RawURLEncoding.EncodeToString(key.KeyID),
+			K:   base64.RawURLEncoding.EncodeToString(key.Key),
+		})
+	}
+
+	response, err := json.Marshal(keySet)
+	if err != nil {
+		http.Error(w, "Error encoding keys", http.StatusInternalServerError)
+		return
+	}
+
+	reqStartTime := r.Context().Value("reqStartTime").(time.Time)
+	reqTook := time.Since(reqStartTime)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(response)))
+	// keys must not end up in shared caches
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Server-Timing", fmt.Sprintf("total;dur=%.3f", reqTook.Seconds()*1000))
+	w.WriteHeader(http.StatusOK)
+
+	w.Write(response)
+}
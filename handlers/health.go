@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+	"github.com/Diniboy1123/manifesto/models"
+	"github.com/Diniboy1123/manifesto/transformers"
+)
+
+// readinessReport is the response of ReadyHandler.
+type readinessReport struct {
+	// Status is "ok" if the service is ready, "unavailable" otherwise
+	Status string `json:"status"`
+	// Channels holds the probe results of every channel, only set if probing was requested
+	// and readiness details are enabled in the config
+	Channels []channelProbeResult `json:"channels,omitempty"`
+}
+
+// channelProbeResult is the result of probing the source manifest of a channel.
+type channelProbeResult struct {
+	Group string `json:"group"`
+	Id    string `json:"id"`
+	// Reachable reports whether the source manifest could be fetched and parsed
+	Reachable bool `json:"reachable"`
+	// IsLive reports whether the source manifest is a live manifest
+	IsLive bool `json:"is_live"`
+	// LastChunkTime is the start time of the latest chunk in seconds, across all stream indexes
+	LastChunkTime float64 `json:"last_chunk_time"`
+	// Drm reports whether the source manifest has protection headers
+	Drm bool `json:"drm"`
+	// Error holds the reason the manifest couldn't be fetched or parsed. It is kept generic,
+	// as upstream errors may contain provider URLs
+	Error string `json:"error,omitempty"`
+}
+
+// HealthHandler reports whether the process is alive and its config is loaded.
+// It responds with 200 OK if the config is loaded, 503 Service Unavailable otherwise.
+//
+// It requires no authentication, so it can be used by container health checks.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if !config.ConfigLoaded {
+		http.Error(w, "Config not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	w.Write([]byte("ok\n"))
+}
+
+// ReadyHandler reports whether the service is ready to serve channels as JSON.
+//
+// By default, it only checks whether the config is loaded. If the "probe" query parameter is set to true,
+// the source manifest of every channel is fetched and the service is only reported as ready if every source
+// manifest is reachable. The result is only reported per channel if readiness details are enabled in the config,
+// as the endpoint would list every channel to anyone otherwise. Probed manifests are cached like any other,
+// so frequent probes don't hammer the origins.
+//
+// It responds with 200 OK if the service is ready, 503 Service Unavailable otherwise.
+// It requires no authentication, so it can be used by container readiness checks.
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	report := readinessReport{Status: "ok"}
+
+	if !config.ConfigLoaded {
+		report.Status = "unavailable"
+	} else if probe, _ := strconv.ParseBool(r.URL.Query().Get("probe")); probe {
+		results := probeChannels()
+		for _, result := range results {
+			if !result.Reachable {
+				report.Status = "unavailable"
+				break
+			}
+		}
+		if config.Get().ReadinessDetails {
+			report.Channels = results
+		}
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, "Error encoding readiness report", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	w.Write(body)
+}
+
+// probeChannels fetches the source manifests of every configured channel concurrently.
+// The results are sorted by group name, channels keep their order from the config.
+func probeChannels() []channelProbeResult {
+	cfg := config.Get()
+
+	groupNames := make([]string, 0, len(cfg.Channels))
+	for groupName := range cfg.Channels {
+		groupNames = append(groupNames, groupName)
+	}
+	slices.Sort(groupNames)
+
+	var results []channelProbeResult
+	var channels []config.Channel
+	for _, groupName := range groupNames {
+		for _, channel := range cfg.Channels[groupName] {
+			results = append(results, channelProbeResult{Group: groupName, Id: channel.Id})
+			channels = append(channels, channel)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, channel := range channels {
+		wg.Add(1)
+		go func(result *channelProbeResult) {
+			defer wg.Done()
+			probeChannel(result, channel, cfg.ManifestTTL(channel))
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// probeChannel fetches the source manifest of the channel and fills in the result.
+func probeChannel(result *channelProbeResult, channel config.Channel, ttl time.Duration) {
+	smoothStream, err := transformers.GetSmoothManifest(channel.Url, ttl)
+	if err != nil {
+		result.Error = getProbeError(err)
+		return
+	}
+
+	result.Reachable = true
+	result.IsLive = smoothStream.IsLive
+	result.Drm = len(smoothStream.Protection) > 0
+	result.LastChunkTime = getLastChunkTime(smoothStream)
+}
+
+// getProbeError returns a generic description of an error returned by transformers.GetSmoothManifest,
+// which doesn't reveal the upstream URL.
+func getProbeError(err error) string {
+	var statusErr *utils.StatusError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &statusErr):
+		return "upstream responded with status " + strconv.Itoa(statusErr.StatusCode)
+	case errors.As(err, &urlErr):
+		return "unreachable"
+	default:
+		return "invalid manifest"
+	}
+}
+
+// getLastChunkTime returns the start time of the latest chunk across all stream indexes in seconds.
+func getLastChunkTime(smoothStream *models.SmoothStream) float64 {
+	var lastChunkTime float64
+	for i := range smoothStream.StreamIndexes {
+		streamIndex := &smoothStream.StreamIndexes[i]
+
+		chunks := streamIndex.GetChunks()
+		if len(chunks) == 0 {
+			continue
+		}
+
+		timeScale := streamIndex.GetTimeScale(smoothStream.TimeScale)
+		lastChunkTime = max(lastChunkTime, float64(chunks[len(chunks)-1].Time)/float64(timeScale))
+	}
+	return lastChunkTime
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/Diniboy1123/manifesto/internal/utils"
+	"github.com/Diniboy1123/manifesto/models"
+)
+
+func TestGetLastChunkTime(t *testing.T) {
+	smoothStream := &models.SmoothStream{
+		TimeScale: 10000000,
+		StreamIndexes: []models.StreamIndex{
+			{
+				ChunkInfos: []models.ChunkInfos{
+					{StartTime: 20000000, Duration: 20000000},
+					{Duration: 20000000},
+				},
+			},
+			{
+				// the audio track uses its own time scale and is ahead of the video track
+				TimeScale: 48000,
+				ChunkInfos: []models.ChunkInfos{
+					{StartTime: 144000, Duration: 96000},
+					{Duration: 96000},
+				},
+			},
+			{},
+		},
+	}
+
+	if lastChunkTime := getLastChunkTime(smoothStream); lastChunkTime != 5 {
+		t.Errorf("Expected last chunk time 5, got %v", lastChunkTime)
+	}
+}
+
+func TestGetProbeError(t *testing.T) {
+	statusErr := fmt.Errorf("error fetching manifest: %w", &utils.StatusError{StatusCode: 403, Status: "403 Forbidden"})
+	if got := getProbeError(statusErr); got != "upstream responded with status 403" {
+		t.Errorf("Expected status error, got %q", got)
+	}
+
+	dialErr := &url.Error{Op: "Get", URL: "http://provider.example/secret/Manifest", Err: errors.New("connection refused")}
+	if got := getProbeError(dialErr); got != "unreachable" {
+		t.Errorf("Expected unreachable, got %q", got)
+	}
+
+	parseErr := errors.New("XML syntax error on line 1: unexpected EOF")
+	if got := getProbeError(parseErr); got != "invalid manifest" {
+		t.Errorf("Expected invalid manifest, got %q", got)
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/transformers"
+)
+
+// HlsMasterPlaylistHandler transforms a SmoothStream manifest to an HLS master playlist.
+// It handles the request, fetches the SmoothStream manifest, transforms it to a master playlist,
+// and writes the playlist to the response.
+//
+// The handler expects the channel information to be present in the request context.
+// If the channel is not found in the context or the channel isn't served as HLS, it returns an error response.
+//
+// The master playlist references one media playlist per representation, which are served by HlsMediaPlaylistHandler.
+func HlsMasterPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := r.Context().Value("channel").(config.Channel)
+	if !ok {
+		http.Error(w, "Channel not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if !channel.ServesDestination(config.DestinationM3u8) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	profile, err := getProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	manifestFetchStartTime := time.Now()
+	smoothStream, err := transformers.GetSmoothManifest(channel.Url, config.Get().ManifestTTL(channel))
+	logUpstreamStatus(r, err)
+	if err != nil {
+		http.Error(w, "Error fetching manifest", http.StatusInternalServerError)
+		log.Printf("Error fetching manifest: %v", err)
+		return
+	}
+	manifestFetchTook := time.Since(manifestFetchStartTime)
+
+	manifestTransformStartTime := time.Now()
+	playlist, err := transformers.SmoothToHlsMasterPlaylist(smoothStream, profile.Option(profile.AllowSubs, config.Get().AllowSubs), getProfileQuery(r))
+	if err != nil {
+		http.Error(w, "Error transforming manifest", http.StatusInternalServerError)
+		log.Printf("Error transforming manifest: %v", err)
+		return
+	}
+	manifestTransformTook := time.Since(manifestTransformStartTime)
+
+	writePlaylist(w, r, playlist, manifestFetchTook, manifestTransformTook)
+}
+
+// HlsMediaPlaylistHandler transforms a single quality level of a SmoothStream manifest to an HLS media playlist.
+//
+// The handler expects the following URL parameters:
+//   - qualityId: The ID of the quality level.
+//
+// The handler also expects the channel information to be present in the request context.
+// If any of the required parameters are missing or invalid, it returns an error response.
+//
+// The media playlist advertises the init segment served by InitHandler via EXT-X-MAP and
+// the media segments served by SegmentHandler.
+func HlsMediaPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := r.Context().Value("channel").(config.Channel)
+	if !ok {
+		http.Error(w, "Channel not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if !channel.ServesDestination(config.DestinationM3u8) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	streamIndexStr, qualityLevelIndex, err := parseQualityId(r.PathValue("qualityId"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	manifestFetchStartTime := time.Now()
+	smoothStream, err := transformers.GetSmoothManifest(channel.Url, config.Get().ManifestTTL(channel))
+	logUpstreamStatus(r, err)
+	if err != nil {
+		http.Error(w, "Error fetching manifest", http.StatusInternalServerError)
+		log.Printf("Error fetching manifest: %v", err)
+		return
+	}
+	manifestFetchTook := time.Since(manifestFetchStartTime)
+
+	// chunks that dropped out of the source manifest are kept for channels with an extended DVR window
+	transformers.ExtendDvrWindow(smoothStream, channel)
+
+	streamIndex, err := smoothStream.GetStreamIndexByNameOrType(streamIndexStr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching stream index: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	qualityLevel, err := streamIndex.GetQualityLevelByIndex(qualityLevelIndex)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error fetching quality level: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	manifestTransformStartTime := time.Now()
+	playlist, err := transformers.SmoothToHlsMediaPlaylist(smoothStream, streamIndex, qualityLevel, channel, getProfileQuery(r))
+	if err != nil {
+		http.Error(w, "Error transforming manifest", http.StatusInternalServerError)
+		log.Printf("Error transforming manifest: %v", err)
+		return
+	}
+	manifestTransformTook := time.Since(manifestTransformStartTime)
+
+	writePlaylist(w, r, playlist, manifestFetchTook, manifestTransformTook)
+}
+
+// writePlaylist writes an HLS playlist to the response with the appropriate headers.
+func writePlaylist(w http.ResponseWriter, r *http.Request, playlist []byte, manifestFetchTook, manifestTransformTook time.Duration) {
+	reqStartTime := r.Context().Value("reqStartTime").(time.Time)
+	reqTook := time.Since(reqStartTime)
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Content-Length", strconv.Itoa(len(playlist)))
+	w.Header().Set("Server-Timing", fmt.Sprintf(
+		"manifest-fetch;dur=%.3f,manifest-transform;dur=%.3f,total;dur=%.3f",
+		manifestFetchTook.Seconds()*1000,
+		manifestTransformTook.Seconds()*1000,
+		reqTook.Seconds()*1000,
+	))
+	w.WriteHeader(http.StatusOK)
+
+	w.Write(playlist)
+}
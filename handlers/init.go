@@ -1,20 +1,18 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/Diniboy1123/manifesto/config"
 	"github.com/Diniboy1123/manifesto/internal/utils"
+	"github.com/Diniboy1123/manifesto/models"
 	"github.com/Diniboy1123/manifesto/segment"
-	"github.com/Diniboy1123/manifesto/segment/audio"
-	"github.com/Diniboy1123/manifesto/segment/subtitle"
-	"github.com/Diniboy1123/manifesto/segment/video"
 	"github.com/Diniboy1123/manifesto/transformers"
-	"github.com/Eyevinn/mp4ff/mp4"
 )
 
 // InitHandler handles requests for the initialization segment of a stream.
@@ -31,6 +29,11 @@ import (
 // The handler supports different stream types (video, audio, text) and generates
 // the initialization segments accordingly. It also takes care of potentially encrypted init segments
 // (if no key is present, we return a segment for encrypted media) and strips encryption data if key is present.
+// If Widevine or ClearKey is enabled for the channel, the matching pssh boxes are added next to the PlayReady one.
+// In ClearKey mode, the init segment keeps its encryption data even if keys are configured.
+// If the manifest lists multiple key IDs, the newest chunk of the quality level is probed to find the key ID of the track.
+//
+// Generated init segments are cached per channel and quality level for the channel's init cache duration.
 //
 // The handler also sets the Content-Disposition header to suggest a filename for the downloaded file.
 // The filename is set to "init.mp4".
@@ -41,25 +44,15 @@ func InitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	qualityId := r.PathValue("qualityId")
-
-	// split from right, because we can have audio_deu_0 where we want audio_deu and 0
-	lastUnderscore := strings.LastIndex(qualityId, "_")
-	if lastUnderscore == -1 || lastUnderscore == len(qualityId)-1 {
-		http.Error(w, "Invalid quality ID format", http.StatusBadRequest)
-		return
-	}
-
-	streamIndexStr := qualityId[:lastUnderscore]
-	qualityLevelIndexStr := qualityId[lastUnderscore+1:]
-	qualityLevelIndex, err := strconv.Atoi(qualityLevelIndexStr)
+	streamIndexStr, qualityLevelIndex, err := parseQualityId(r.PathValue("qualityId"))
 	if err != nil {
-		http.Error(w, "Invalid quality level index", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	manifestFetchStartTime := time.Now()
-	smoothStream, err := transformers.GetSmoothManifest(channel.Url)
+	smoothStream, err := transformers.GetSmoothManifest(channel.Url, config.Get().InitTTL(channel))
+	logUpstreamStatus(r, err)
 	if err != nil {
 		http.Error(w, "Error fetching manifest", http.StatusInternalServerError)
 		return
@@ -78,60 +71,20 @@ func InitHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var keyId, key, pssh []byte
-	if smoothStream.Protection != nil {
-		keyId, key, pssh, err = utils.ExtractKeyInfo(smoothStream.Protection, channel)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("DRM Error: %v", err), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	baseSegment := segment.BaseInitSegment{
-		TimeScale:        uint32(smoothStream.TimeScale),
-		Lang:             streamIndex.Language,
-		CodecPrivateData: qualityLevel.CodecPrivateData,
-	}
-	if keyId != nil {
-		baseSegment.KeyId = keyId
-		baseSegment.Key = key
-		baseSegment.Pssh = pssh
-	}
+	// init segments only depend on the manifest, so they are generated once and served from the cache afterwards
+	cacheKey := fmt.Sprintf("%s/%s/%s/init", r.PathValue("groupId"), channel.Id, r.PathValue("qualityId"))
 
 	initGenStartTime := time.Now()
-	var initSegment *mp4.InitSegment
-	switch streamIndex.Type {
-	case "video":
-		avcInitSegment := video.AVCInitSegment{BaseInitSegment: baseSegment}
-		initSegment, _, err = avcInitSegment.Generate()
-	case "audio":
-		switch strings.ToLower(qualityLevel.FourCC) {
-		case "aacl":
-			aacInitSegment := audio.AACInitSegment{BaseInitSegment: baseSegment}
-			initSegment, _, err = aacInitSegment.Generate()
-		case "ec-3":
-			de3InitSegment := audio.De3InitSegment{BaseInitSegment: baseSegment}
-			initSegment, _, err = de3InitSegment.Generate()
-		default:
-			http.Error(w, "Unsupported audio codec", http.StatusBadRequest)
-			return
-		}
-	case "text":
-		switch strings.ToLower(qualityLevel.FourCC) {
-		case "ttml":
-			stppInitSegment := subtitle.STPPInitSegment{BaseInitSegment: baseSegment}
-			initSegment, err = stppInitSegment.Generate()
-		default:
-			http.Error(w, "Unsupported text codec", http.StatusBadRequest)
-			return
-		}
-	default:
-		http.Error(w, "Unsupported stream type", http.StatusBadRequest)
+	output, cached, err := utils.GetOrProcess(cacheKey, config.Get().InitTTL(channel), func() ([]byte, error) {
+		return generateInitSegment(channel, smoothStream, streamIndex, qualityLevel)
+	})
+	logCacheResult(r, cached)
+	if errors.Is(err, transformers.ErrUnsupportedCodec) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error generating init segment: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	initGenTook := time.Since(initGenStartTime)
@@ -141,12 +94,68 @@ func InitHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", streamIndex.GetMimeType())
 	w.Header().Set("Content-Disposition", "attachment; filename=init.mp4")
+	w.Header().Set("Content-Length", strconv.Itoa(len(output)))
 	w.Header().Set("Server-Timing", fmt.Sprintf(
-		"manifest-fetch;dur=%.3f,init-gen;dur=%.3f,total;dur=%.3f",
+		"manifest-fetch;dur=%.3f,init-gen;dur=%.3f,total;dur=%.3f%s",
 		manifestFetchTook.Seconds()*1000,
 		initGenTook.Seconds()*1000,
 		reqTook.Seconds()*1000,
+		cacheTiming(cached),
 	))
 	w.WriteHeader(http.StatusOK)
-	initSegment.Encode(w)
+
+	w.Write(output)
+}
+
+// generateInitSegment generates the encoded init segment of a quality level, including
+// the encryption data of the track unless it gets decrypted.
+//
+// If the codec of the quality level isn't supported, it returns transformers.ErrUnsupportedCodec as is.
+// Other errors are formatted so they can be shown to the client.
+func generateInitSegment(channel config.Channel, smoothStream *models.SmoothStream, streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel) ([]byte, error) {
+	var keyId, key, pssh []byte
+	if smoothStream.Protection != nil {
+		trackKeyId, err := probeTrackKeyId(channel, smoothStream.Protection, streamIndex, qualityLevel)
+		if err != nil {
+			return nil, fmt.Errorf("DRM Error: %v", err)
+		}
+
+		keyId, key, pssh, err = utils.ExtractKeyInfo(smoothStream.Protection, channel, trackKeyId)
+		if err != nil {
+			return nil, fmt.Errorf("DRM Error: %v", err)
+		}
+	}
+
+	baseSegment := segment.BaseInitSegment{
+		TimeScale:        uint32(streamIndex.GetTimeScale(smoothStream.TimeScale)),
+		Lang:             streamIndex.Language,
+		CodecPrivateData: qualityLevel.CodecPrivateData,
+	}
+	if keyId != nil {
+		baseSegment.KeyId = keyId
+		baseSegment.Pssh = pssh
+		if channel.ShouldDecrypt() {
+			baseSegment.Key = key
+		}
+
+		var err error
+		baseSegment.ExtraPsshBoxes, err = getExtraPsshBoxes(channel, smoothStream.Protection, keyId)
+		if err != nil {
+			return nil, fmt.Errorf("DRM Error: %v", err)
+		}
+	}
+
+	initSegment, _, err := transformers.GenerateInitSegment(streamIndex, qualityLevel, baseSegment)
+	if errors.Is(err, transformers.ErrUnsupportedCodec) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error generating init segment: %v", err)
+	}
+
+	output := bytes.NewBuffer(nil)
+	if err := initSegment.Encode(output); err != nil {
+		return nil, fmt.Errorf("Error encoding init segment: %v", err)
+	}
+	return output.Bytes(), nil
 }
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+	"github.com/Diniboy1123/manifesto/middleware"
+)
+
+// maxLicenseChallengeSize is the maximum size of a license challenge accepted from clients.
+// PlayReady and Widevine challenges are a few kilobytes at most.
+const maxLicenseChallengeSize = 1 << 20
+
+// licenseRequestHeaders lists the client headers that are forwarded to the license server.
+// Other headers are dropped, so clients can't override the configured license headers.
+var licenseRequestHeaders = []string{"Content-Type", "SOAPAction"}
+
+// LicenseHandler forwards a license challenge to the license server configured for the channel.
+// It reads the challenge from the request body, sends it to the channel's license URL with the
+// configured license headers and writes the license server's response back to the client.
+//
+// The handler expects the channel information to be present in the request context.
+// If the channel has no license URL configured, it returns a 404 Not Found error.
+//
+// License requests are never cached, as every challenge is unique.
+func LicenseHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := r.Context().Value("channel").(config.Channel)
+	if !ok {
+		http.Error(w, "Channel not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if channel.LicenseUrl == "" {
+		http.Error(w, "License proxy not configured", http.StatusNotFound)
+		return
+	}
+
+	challenge, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLicenseChallengeSize))
+	if err != nil {
+		http.Error(w, "Error reading license challenge", http.StatusBadRequest)
+		return
+	}
+
+	headers := make(map[string]string, len(licenseRequestHeaders)+len(channel.LicenseHeaders))
+	for _, name := range licenseRequestHeaders {
+		if value := r.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	for name, value := range channel.LicenseHeaders {
+		headers[name] = value
+	}
+
+	licenseFetchStartTime := time.Now()
+	licenseResp, err := utils.DoUncachedRequest(http.MethodPost, channel.LicenseUrl, bytes.NewReader(challenge), headers)
+	if err != nil {
+		http.Error(w, "Error fetching license", http.StatusBadGateway)
+		log.Printf("Error fetching license: %v", err)
+		return
+	}
+	defer licenseResp.Body.Close()
+	middleware.GetRequestLog(r).UpstreamStatus = licenseResp.StatusCode
+
+	license, err := io.ReadAll(licenseResp.Body)
+	if err != nil {
+		http.Error(w, "Error reading license", http.StatusBadGateway)
+		log.Printf("Error reading license: %v", err)
+		return
+	}
+	licenseFetchTook := time.Since(licenseFetchStartTime)
+
+	reqStartTime := r.Context().Value("reqStartTime").(time.Time)
+	reqTook := time.Since(reqStartTime)
+
+	// license servers report errors (e.g. PlayReady SOAP faults) in the body, so it is passed through as-is
+	if contentType := licenseResp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(license)))
+	w.Header().Set("Server-Timing", fmt.Sprintf(
+		"license-fetch;dur=%.3f,total;dur=%.3f",
+		licenseFetchTook.Seconds()*1000,
+		reqTook.Seconds()*1000,
+	))
+	w.WriteHeader(licenseResp.StatusCode)
+
+	w.Write(license)
+}
@@ -16,11 +16,13 @@ import (
 // and writes the DASH manifest to the response.
 //
 // The handler expects the channel information to be present in the request context.
-// If the channel is not found in the context, it returns an error response.
+// If the channel is not found in the context or the channel isn't served as DASH, it returns an error response.
 //
 // If any error occurs during the fetching or transformation process, it logs the error
 // and returns an error response to the client.
 //
+// Requests matching a player compatibility profile get the manifest tweaked accordingly, see getProfile.
+//
 // The handler also sets the Content-Type header to "application/dash+xml" and writes
 // the transformed DASH manifest to the response body.
 func DashManifestHandler(w http.ResponseWriter, r *http.Request) {
@@ -30,8 +32,23 @@ func DashManifestHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !channel.ServesDestination(config.DestinationMpd) {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	profile, err := getProfile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !profile.Option(profile.Delay, true) {
+		channel.Delay = 0
+	}
+
 	manifestFetchStartTime := time.Now()
-	smoothStream, err := transformers.GetSmoothManifest(channel.Url)
+	smoothStream, err := transformers.GetSmoothManifest(channel.Url, config.Get().ManifestTTL(channel))
+	logUpstreamStatus(r, err)
 	if err != nil {
 		http.Error(w, "Error fetching manifest", http.StatusInternalServerError)
 		log.Printf("Error fetching manifest: %v", err)
@@ -39,19 +56,40 @@ func DashManifestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	manifestFetchTook := time.Since(manifestFetchStartTime)
 
-	var hasKeys bool
-	if channel.Keys != nil {
-		hasKeys = true
+	// chunks that dropped out of the source manifest are kept for channels with an extended DVR window
+	transformers.ExtendDvrWindow(smoothStream, channel)
+
+	// in ClearKey mode the keys are handed out to players, so the manifest must keep its encryption data
+	hasKeys := channel.ShouldDecrypt()
+
+	// the license proxy and the key endpoint are advertised with absolute URLs, as players resolve them against the page origin otherwise
+	var licenseUrl, clearKeyUrl string
+	if channel.LicenseUrl != "" {
+		licenseUrl = getSiblingUrl(r, "license")
+	}
+	if channel.ClearKey {
+		clearKeyUrl = getSiblingUrl(r, "clearkey")
 	}
 
 	manifestTransformStartTime := time.Now()
-	mpd, err := transformers.SmoothToDashManifest(smoothStream, hasKeys, config.Get().AllowSubs, channel)
+	allowSubs := profile.Option(profile.AllowSubs, config.Get().AllowSubs)
+	mpd, err := transformers.SmoothToDashManifest(smoothStream, hasKeys, allowSubs, channel, licenseUrl, clearKeyUrl)
 	if err != nil {
 		http.Error(w, "Error transforming manifest", http.StatusInternalServerError)
 		log.Printf("Error transforming manifest: %v", err)
 		return
 	}
 
+	// players don't carry the query of the manifest over to segment requests
+	if profileQuery := getProfileQuery(r); profileQuery != "" {
+		for _, period := range mpd.Period {
+			for _, adaptationSet := range period.AdaptationSets {
+				adaptationSet.SegmentTemplate.Media += profileQuery
+				adaptationSet.SegmentTemplate.Initialization += profileQuery
+			}
+		}
+	}
+
 	mpdXML, err := mpd.Encode()
 	if err != nil {
 		http.Error(w, "Error encoding manifest", http.StatusInternalServerError)
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/Diniboy1123/manifesto/internal/metrics"
+)
+
+// MetricsHandler exposes the collected metrics in the Prometheus text exposition format.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/recorder"
+)
+
+// recordingRequest is the body of recording creation requests.
+type recordingRequest struct {
+	// Group is the group name of the channel to record
+	Group string `json:"group"`
+	// Channel is the ID of the channel to record
+	Channel string `json:"channel"`
+	// Representations holds the IDs of the quality levels to record (e.g. "video_0"), all of them if empty
+	Representations []string `json:"representations"`
+	// Start is when the recording starts, right away if unset
+	Start time.Time `json:"start"`
+	// End is when the recording ends, either End or Duration must be set
+	End time.Time `json:"end"`
+	// Duration is how long the recording lasts from its start, either End or Duration must be set
+	Duration config.JSONDuration `json:"duration"`
+}
+
+// RecordingListHandler lists every recording with its state as JSON, sorted by start time.
+func RecordingListHandler(w http.ResponseWriter, r *http.Request) {
+	recordings, err := recorder.List()
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, struct {
+		Recordings []recorder.Recording `json:"recordings"`
+	}{recordings})
+}
+
+// RecordingHandler returns the state of a single recording as JSON.
+//
+// The handler expects the following URL parameters:
+//   - recordingId: The ID of the recording.
+func RecordingHandler(w http.ResponseWriter, r *http.Request) {
+	rec, err := recorder.Get(r.PathValue("recordingId"))
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+
+	writeJson(w, http.StatusOK, rec)
+}
+
+// RecordingCreateHandler creates a recording of a live channel from a JSON body (see recordingRequest)
+// and starts it in the background. It responds with 201 Created and the state of the new recording.
+//
+// If the parameters are invalid, it returns a 400 Bad Request response.
+// If the same channel is already recorded from the same start time, it returns a 409 Conflict response.
+func RecordingCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var req recordingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	end := req.End
+	if req.Duration > 0 {
+		if !end.IsZero() {
+			http.Error(w, "Only one of end and duration can be set", http.StatusBadRequest)
+			return
+		}
+
+		start := req.Start
+		if start.IsZero() {
+			start = time.Now()
+		}
+		end = start.Add(req.Duration.Duration())
+	}
+
+	rec, err := recorder.Create(req.Group, req.Channel, req.Representations, req.Start, end)
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+	recorder.Start(rec.Id)
+
+	writeJson(w, http.StatusCreated, rec)
+}
+
+// RecordingStopHandler stops a running recording, keeping what was recorded so far.
+// It responds with the final state of the recording.
+//
+// If the recording isn't running, it returns a 409 Conflict response.
+func RecordingStopHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("recordingId")
+	if _, err := recorder.Get(id); err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+
+	if err := recorder.Stop(id); err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+
+	RecordingHandler(w, r)
+}
+
+// RecordingDeleteHandler deletes a recording with all of its files, stopping it first if it is running.
+// It responds with 204 No Content.
+func RecordingDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if err := recorder.Delete(r.PathValue("recordingId")); err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RecordingManifestHandler serves the static MPEG-DASH manifest of a recording.
+// Recordings in progress are served with everything recorded so far.
+//
+// The handler expects the following URL parameters:
+//   - recordingId: The ID of the recording.
+func RecordingManifestHandler(w http.ResponseWriter, r *http.Request) {
+	mpdPath, err := recorder.ManifestPath(r.PathValue("recordingId"))
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+
+	serveRecordingFile(w, r, mpdPath, "application/dash+xml")
+}
+
+// RecordingInitHandler serves the init segment of a recorded representation.
+//
+// The handler expects the following URL parameters:
+//   - recordingId: The ID of the recording.
+//   - qualityId: The ID of the quality level.
+func RecordingInitHandler(w http.ResponseWriter, r *http.Request) {
+	rec, err := recorder.Get(r.PathValue("recordingId"))
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+
+	initPath, err := recorder.InitPath(rec.Id, r.PathValue("qualityId"))
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=init.mp4")
+	serveRecordingFile(w, r, initPath, rec.MimeTypes[r.PathValue("qualityId")])
+}
+
+// RecordingSegmentHandler serves a recorded segment. The URL has the same form as for live channels,
+// so the MPEG-DASH manifests of recordings are built by the same transformer, but only the time is used.
+//
+// The handler expects the following URL parameters:
+//   - recordingId: The ID of the recording.
+//   - qualityId: The ID of the quality level.
+//   - time: The time of the segment.
+func RecordingSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	segmentTime, err := strconv.ParseUint(r.PathValue("time"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid time format", http.StatusBadRequest)
+		return
+	}
+
+	rec, err := recorder.Get(r.PathValue("recordingId"))
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+
+	segmentPath, err := recorder.SegmentPath(rec.Id, r.PathValue("qualityId"), segmentTime)
+	if err != nil {
+		writeRecordingError(w, err)
+		return
+	}
+
+	serveRecordingFile(w, r, segmentPath, rec.MimeTypes[r.PathValue("qualityId")])
+}
+
+// serveRecordingFile writes a file of a recording to the response with the given content type.
+// If the file doesn't exist (yet), it returns a 404 Not Found response.
+func serveRecordingFile(w http.ResponseWriter, r *http.Request, path, contentType string) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading recording", http.StatusInternalServerError)
+		return
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	reqStartTime := r.Context().Value("reqStartTime").(time.Time)
+	reqTook := time.Since(reqStartTime)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Server-Timing", fmt.Sprintf("total;dur=%.3f", reqTook.Seconds()*1000))
+	w.WriteHeader(http.StatusOK)
+
+	w.Write(data)
+}
+
+// writeRecordingError responds with the status code matching an error returned by the recorder package.
+func writeRecordingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, recorder.ErrNotFound), errors.Is(err, recorder.ErrDisabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, recorder.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, recorder.ErrExists), errors.Is(err, recorder.ErrNotRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeJson encodes v as the JSON response body with the given status code.
+func writeJson(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+
+	w.Write(body)
+}
@@ -1,20 +1,17 @@
 package handlers
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/metrics"
 	"github.com/Diniboy1123/manifesto/internal/utils"
+	"github.com/Diniboy1123/manifesto/models"
 	"github.com/Diniboy1123/manifesto/segment"
-	"github.com/Diniboy1123/manifesto/segment/audio"
-	"github.com/Diniboy1123/manifesto/segment/subtitle"
-	"github.com/Diniboy1123/manifesto/segment/video"
 	"github.com/Diniboy1123/manifesto/transformers"
 	"github.com/Eyevinn/mp4ff/mp4"
 )
@@ -27,15 +24,24 @@ import (
 //   - channelId: The ID of the channel.
 //   - qualityId: The ID of the quality level.
 //   - time: The time of the segment.
-//   - rest: The remaining part of the URL, which is the chunk name.
+//   - rest: The remaining part of the URL, which is the chunk path. It must match the chunk path of the
+//     quality level and time, as the chunk is always fetched from the path built on our side.
 //
 // The handler also expects the channel information to be present in the request context.
 // If any of the required parameters are missing or invalid, it returns an error response.
 //
 // The handler supports different stream types (video, audio, text) and processes
 // the segments accordingly. It also handles PR based segment decryption by extracting the key ID
-// and PSSH data from the manifest. The processed segment is returned with the appropriate
+// from the segment (falling back to the manifest) and the PSSH data from the manifest, so tracks
+// encrypted with different keys are each decrypted with their own key. The processed segment is returned with the appropriate
 // content type (video/mp4, audio/mp4, application/mp4).
+//
+// Requests matching a player compatibility profile get the segment repackaged accordingly, see getProfile.
+// Processed segments are cached per channel, quality level, time and profile for the channel's segment cache duration,
+// so repeated requests skip repackaging entirely.
+//
+// For live channels with prefetching enabled, the following segments of the same quality level
+// are fetched in the background after the response is written.
 func SegmentHandler(w http.ResponseWriter, r *http.Request) {
 	channel, ok := r.Context().Value("channel").(config.Channel)
 	if !ok {
@@ -60,31 +66,32 @@ func SegmentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	qualityId := r.PathValue("qualityId")
-
-	// split from right, because we can have audio_deu_0 where we want audio_deu and 0
-	lastUnderscore := strings.LastIndex(qualityId, "_")
-	if lastUnderscore == -1 || lastUnderscore == len(qualityId)-1 {
-		http.Error(w, "Invalid quality ID format", http.StatusBadRequest)
+	streamIndexStr, qualityLevelIndex, err := parseQualityId(r.PathValue("qualityId"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	streamIndexStr := qualityId[:lastUnderscore]
-	qualityLevelIndexStr := qualityId[lastUnderscore+1:]
-	qualityLevelIndex, err := strconv.Atoi(qualityLevelIndexStr)
+	profile, err := getProfile(r)
 	if err != nil {
-		http.Error(w, "Invalid quality level index", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	// the timeline must be up to date, as the duration of the chunk and the chunks to prefetch are looked up in it
 	manifestFetchStartTime := time.Now()
-	smoothStream, err := transformers.GetSmoothManifest(channel.Url)
+	smoothStream, err := transformers.GetSmoothManifest(channel.Url, config.Get().ManifestTTL(channel))
 	if err != nil {
+		logUpstreamStatus(r, err)
 		http.Error(w, "Error fetching manifest", http.StatusInternalServerError)
 		return
 	}
 	manifestFetchTook := time.Since(manifestFetchStartTime)
 
+	// players may only request segments for a while, so the DVR window keeps growing meanwhile,
+	// and chunks that dropped out of the source manifest keep their durations
+	transformers.ExtendDvrWindow(smoothStream, channel)
+
 	streamIndex, err := smoothStream.GetStreamIndexByNameOrType(streamIndexStr)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error fetching stream index: %v", err), http.StatusInternalServerError)
@@ -97,104 +104,51 @@ func SegmentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var keyId, key, pssh []byte
-	if smoothStream.Protection != nil {
-		keyId, key, pssh, err = utils.ExtractKeyInfo(smoothStream.Protection, channel)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("DRM Error: %v", err), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	baseSegment := segment.BaseInitSegment{
-		TimeScale:        uint32(smoothStream.TimeScale),
-		Lang:             streamIndex.Language,
-		CodecPrivateData: qualityLevel.CodecPrivateData,
-	}
-	if keyId != nil {
-		baseSegment.KeyId = keyId
-		baseSegment.Key = key
-		baseSegment.Pssh = pssh
-	}
-
-	initGenStartTime := time.Now()
-	var decryptInfo mp4.DecryptInfo
 	switch streamIndex.Type {
-	case "video":
-		avcInitSegment := video.AVCInitSegment{BaseInitSegment: baseSegment}
-		_, decryptInfo, err = avcInitSegment.Generate()
-	case "audio":
-		switch qualityLevel.FourCC {
-		case "AACL":
-			aacInitSegment := audio.AACInitSegment{BaseInitSegment: baseSegment}
-			_, decryptInfo, err = aacInitSegment.Generate()
-		case "EC-3":
-			de3InitSegment := audio.De3InitSegment{BaseInitSegment: baseSegment}
-			_, decryptInfo, err = de3InitSegment.Generate()
-		default:
-			http.Error(w, "Unsupported audio codec", http.StatusBadRequest)
-			return
-		}
-	case "text":
-		// subtitle decryption isn't supported, so we don't need decryptInfo
+	case "video", "audio", "text":
 	default:
 		http.Error(w, "Unsupported stream type", http.StatusBadRequest)
 		return
 	}
 
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error generating init segment: %v", err), http.StatusInternalServerError)
+	// processed segments are shared by everyone requesting the same quality level and time,
+	// so clients must not be able to get another chunk cached under them
+	if rest != transformers.GetChunkPath(streamIndex, qualityLevel, segmentTime) {
+		http.Error(w, "Chunk doesn't match the quality level and time", http.StatusBadRequest)
 		return
 	}
-	initGenTook := time.Since(initGenStartTime)
-
-	// fetch channel.Url minus the last part of the path + rest
-	chunkBase := channel.Url[:strings.LastIndex(channel.Url, "/")+1]
-	chunkUrl := chunkBase + rest
 
-	chunkFetchStartTime := time.Now()
-	chunkReq, err := utils.DoRequest("GET", chunkUrl, nil)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error fetching chunk: %v", err), http.StatusInternalServerError)
-		return
+	// repackaging is expensive, so every segment is processed once and served from the cache afterwards
+	// segments processed for a profile may differ, so they are cached separately
+	cacheKey := fmt.Sprintf("%s/%s/%s/%d", r.PathValue("groupId"), channel.Id, r.PathValue("qualityId"), segmentTime)
+	if profile.Name != "" {
+		cacheKey += "/" + profile.Name
 	}
-	defer chunkReq.Body.Close()
-	chunkFetchTook := time.Since(chunkFetchStartTime)
 
-	if chunkReq.StatusCode != http.StatusOK {
-		http.Error(w, fmt.Sprintf("Error fetching chunk: %s", chunkReq.Status), http.StatusInternalServerError)
-		return
+	var timings segmentTimings
+	output, cached, err := utils.GetOrProcess(cacheKey, config.Get().SegmentTTL(channel), func() ([]byte, error) {
+		return processSegment(channel, profile, smoothStream, streamIndex, qualityLevel, segmentTime, &timings)
+	})
+	logCacheResult(r, cached)
+	if !cached {
+		// the upstream status of a segment is the one of its chunk
+		logUpstreamStatus(r, err)
 	}
-
-	chunkData, err := io.ReadAll(chunkReq.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading chunk data: %v", err), http.StatusInternalServerError)
+	if errors.Is(err, transformers.ErrUnsupportedCodec) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	segmentProcessStartTime := time.Now()
-	var output []byte
-	switch streamIndex.Type {
-	case "video":
-		output, err = video.ProcessVideoSegment(bytes.NewBuffer(chunkData), decryptInfo, key, segmentTime)
-	case "audio":
-		output, err = audio.ProcessAudioSegment(bytes.NewBuffer(chunkData), decryptInfo, key, segmentTime)
-	case "text":
-		var firstSegmentDuration uint32
-		if len(streamIndex.ChunkInfos) > 0 {
-			firstSegmentDuration = uint32(streamIndex.ChunkInfos[0].Duration)
-		}
-		output, err = subtitle.ProcessSubtitleSegment(bytes.NewBuffer(chunkData), segmentTime, uint32(streamIndex.TimeScale), firstSegmentDuration)
-	default:
-		http.Error(w, "Unsupported stream type", http.StatusBadRequest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error processing segment: %v", err), http.StatusInternalServerError)
-		return
+	metrics.SegmentPhaseDuration.Observe(manifestFetchTook.Seconds(), "manifest-fetch")
+	if !cached {
+		metrics.SegmentPhaseDuration.Observe(timings.chunkFetch.Seconds(), "chunk-fetch")
+		metrics.SegmentPhaseDuration.Observe(timings.initGen.Seconds(), "init-gen")
+		metrics.SegmentPhaseDuration.Observe(timings.segmentProcess.Seconds(), "segment-process")
 	}
-	segmentProcessTook := time.Since(segmentProcessStartTime)
 
 	reqStartTime := r.Context().Value("reqStartTime").(time.Time)
 	reqTook := time.Since(reqStartTime)
@@ -202,14 +156,101 @@ func SegmentHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", streamIndex.GetMimeType())
 	w.Header().Set("Content-Length", strconv.Itoa(len(output)))
 	w.Header().Set("Server-Timing", fmt.Sprintf(
-		"manifest-fetch;dur=%.3f,init-gen;dur=%.3f,chunk-fetch;dur=%.3f,segment-process;dur=%.3f,total;dur=%.3f",
+		"manifest-fetch;dur=%.3f,init-gen;dur=%.3f,chunk-fetch;dur=%.3f,segment-process;dur=%.3f,total;dur=%.3f%s",
 		manifestFetchTook.Seconds()*1000,
-		initGenTook.Seconds()*1000,
-		chunkFetchTook.Seconds()*1000,
-		segmentProcessTook.Seconds()*1000,
+		timings.initGen.Seconds()*1000,
+		timings.chunkFetch.Seconds()*1000,
+		timings.segmentProcess.Seconds()*1000,
 		reqTook.Seconds()*1000,
+		cacheTiming(cached),
 	))
 	w.WriteHeader(http.StatusOK)
 
 	w.Write(output)
+
+	if smoothStream.IsLive && channel.Prefetch > 0 {
+		prefetchNextChunks(channel, streamIndex, qualityLevel, segmentTime)
+	}
+}
+
+// segmentTimings holds the durations of the phases of processSegment, reported in the Server-Timing header.
+// They stay zero if the segment is served from the cache.
+type segmentTimings struct {
+	initGen        time.Duration
+	chunkFetch     time.Duration
+	segmentProcess time.Duration
+}
+
+// processSegment fetches a chunk from the source and repackages it, decrypting it with the key of the track if needed.
+// The repackaging is tweaked as the player compatibility profile tells, the zero Profile keeps the default behavior.
+// The durations of the phases are recorded in timings.
+//
+// If the codec of the quality level isn't supported, it returns transformers.ErrUnsupportedCodec as is.
+// Other errors are formatted so they can be shown to the client.
+func processSegment(channel config.Channel, profile config.Profile, smoothStream *models.SmoothStream, streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel, segmentTime uint64, timings *segmentTimings) ([]byte, error) {
+	// the chunk is fetched first, because it tells us which key the track is encrypted with
+	chunkFetchStartTime := time.Now()
+	chunkUrl := transformers.GetChunkUrl(channel.Url, transformers.GetChunkPath(streamIndex, qualityLevel, segmentTime))
+	chunkData, err := fetchChunk(chunkUrl, config.Get().SegmentTTL(channel))
+	if err != nil {
+		return nil, err
+	}
+	timings.chunkFetch = time.Since(chunkFetchStartTime)
+
+	var keyId, key, pssh []byte
+	if smoothStream.Protection != nil {
+		keyId, key, pssh, err = utils.ExtractKeyInfo(smoothStream.Protection, channel, transformers.GetTrackKeyId(channel, streamIndex, qualityLevel, chunkData))
+		if err != nil {
+			return nil, fmt.Errorf("DRM Error: %v", err)
+		}
+
+		// in ClearKey mode, players decrypt the segments themselves
+		if !channel.ShouldDecrypt() {
+			key = nil
+		}
+	}
+
+	baseSegment := segment.BaseInitSegment{
+		TimeScale:        uint32(streamIndex.GetTimeScale(smoothStream.TimeScale)),
+		Lang:             streamIndex.Language,
+		CodecPrivateData: qualityLevel.CodecPrivateData,
+	}
+	if keyId != nil {
+		baseSegment.KeyId = keyId
+		baseSegment.Key = key
+		baseSegment.Pssh = pssh
+	}
+
+	initGenStartTime := time.Now()
+	var decryptInfo mp4.DecryptInfo
+	if streamIndex.Type == "video" || streamIndex.Type == "audio" {
+		// subtitle decryption isn't supported, so we don't need decryptInfo for text
+		_, decryptInfo, err = transformers.GenerateInitSegment(streamIndex, qualityLevel, baseSegment)
+	}
+	if errors.Is(err, transformers.ErrUnsupportedCodec) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error generating init segment: %v", err)
+	}
+	timings.initGen = time.Since(initGenStartTime)
+
+	segmentProcessStartTime := time.Now()
+	output, err := transformers.ProcessSegment(streamIndex, uint64(baseSegment.TimeScale), chunkData, segment.ProcessOptions{
+		DecryptInfo: decryptInfo,
+		Key:         key,
+		Time:        segmentTime,
+		RewriteTfdt: channel.RewriteTfdt,
+		// profile options default to true, the zero values of the process options keep the default behavior
+		SkipTfdt:         !profile.Option(profile.Tfdt, true),
+		KeepSdtp:         !profile.Option(profile.StripSdtp, true),
+		SkipSidx:         !profile.Option(profile.SubtitleSidx, true),
+		KeepSubtitleTrun: !profile.Option(profile.SubtitleTrun, true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error processing segment: %v", err)
+	}
+	timings.segmentProcess = time.Since(segmentProcessStartTime)
+
+	return output, nil
 }
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Diniboy1123/manifesto/config"
+)
+
+func TestSegmentHandlerRejectsForeignChunks(t *testing.T) {
+	manifest, err := os.ReadFile("../transformers/testdata/vod.ismc")
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+
+	var mu sync.Mutex
+	var chunkPaths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/vod/Manifest" {
+			w.Write(manifest)
+			return
+		}
+		mu.Lock()
+		chunkPaths = append(chunkPaths, r.URL.Path)
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	configData := `{"http_port": 8080, "bind_addr": "127.0.0.1", "save_dir": "` + dir + `", "cache_duration": "1m",
+		"cache_backend": "memory", "channels": {"g": [{"id": "vod", "url": "` + upstream.URL + `/vod/Manifest"}]}}`
+	if err := os.WriteFile(configPath, []byte(configData), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := config.LoadConfig(configPath); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	channel, _ := config.Get().GetChannel("g", "vod")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stream/{groupId}/{channelId}/{qualityId}/{time}/{rest...}", func(w http.ResponseWriter, r *http.Request) {
+		SegmentHandler(w, r.WithContext(context.WithValue(r.Context(), "channel", channel)))
+	})
+	fetched := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(chunkPaths)
+	}
+	request := func(path string) int {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	// the audio chunk must not end up in the cache of the video quality level
+	if status := request("/stream/g/vod/video_0/20000000/QualityLevels(128000)/Fragments(audio_deu=20000000)"); status != http.StatusBadRequest {
+		t.Errorf("Expected a foreign chunk to be rejected, got status %d", status)
+	}
+	if paths := fetched(); len(paths) != 0 {
+		t.Errorf("Expected no chunk to be fetched, got %v", paths)
+	}
+
+	// the upstream chunk doesn't exist, but it must be the one of the quality level
+	request("/stream/g/vod/video_0/20000000/QualityLevels(1300000)/Fragments(video=20000000)")
+	if paths := fetched(); !slices.ContainsFunc(paths, func(path string) bool {
+		return strings.HasSuffix(path, "/vod/QualityLevels(1300000)/Fragments(video=20000000)")
+	}) {
+		t.Errorf("Expected the chunk of the quality level to be fetched, got %v", paths)
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+)
+
+// signedUrlResponse is the response of SignUrlHandler.
+type signedUrlResponse struct {
+	// Url is the signed manifest URL players should use, based on the channel's destination type
+	Url string `json:"url"`
+	// DashUrl is the signed MPEG-DASH manifest URL, if the channel is served as MPEG-DASH
+	DashUrl string `json:"dash_url,omitempty"`
+	// HlsUrl is the signed HLS master playlist URL, if the channel is served as HLS
+	HlsUrl string `json:"hls_url,omitempty"`
+	// Expires is the time the URLs stop working at
+	Expires time.Time `json:"expires"`
+}
+
+// SignUrlHandler issues signed manifest URLs of a channel or recording for the requesting user, which can be
+// handed to players instead of URLs containing the user's token. The URLs expire after the duration given in the
+// "ttl" query parameter (e.g. "6h"), utils.SignedUrlTTL if not set. Durations longer than
+// utils.MaxSignedUrlTTL are rejected.
+//
+// The handler is only reachable with a token, so signed URLs can't be used to extend themselves.
+// If URL signing isn't configured, it returns a 404 Not Found response.
+func SignUrlHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := config.Get()
+	user, _ := r.Context().Value("user").(*config.User)
+	if cfg.UrlSigningKey == "" || user == nil {
+		http.Error(w, "URL signing is disabled", http.StatusNotFound)
+		return
+	}
+
+	ttl := utils.SignedUrlTTL()
+	if ttlStr := r.URL.Query().Get("ttl"); ttlStr != "" {
+		var err error
+		ttl, err = time.ParseDuration(ttlStr)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "Invalid ttl", http.StatusBadRequest)
+			return
+		}
+	}
+	if maxTTL := utils.MaxSignedUrlTTL(); ttl > maxTTL {
+		http.Error(w, fmt.Sprintf("ttl exceeds the maximum of %s", maxTTL), http.StatusBadRequest)
+		return
+	}
+	// signatures have a precision of a second
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+
+	groupId, channelId := r.PathValue("groupId"), r.PathValue("channelId")
+	var response signedUrlResponse
+	if recordingId := r.PathValue("recordingId"); recordingId != "" {
+		// recordings are only served as MPEG-DASH
+		groupId, channelId = config.RecordingsGroup, recordingId
+		response.DashUrl = "manifest.mpd"
+	} else {
+		channel, ok := r.Context().Value("channel").(config.Channel)
+		if !ok {
+			http.Error(w, "Channel not found in context", http.StatusInternalServerError)
+			return
+		}
+		if channel.ServesDestination(config.DestinationMpd) {
+			response.DashUrl = "manifest.mpd"
+		}
+		if channel.ServesDestination(config.DestinationM3u8) {
+			response.HlsUrl = "master.m3u8"
+		}
+	}
+
+	channelUrl := getBaseUrl(r) +
+		utils.SignStreamPath(cfg.UrlSigningKey, user.Username, groupId+"/"+channelId, expires) +
+		"/stream/" + url.PathEscape(groupId) + "/" + url.PathEscape(channelId) + "/"
+
+	if response.DashUrl != "" {
+		response.DashUrl = channelUrl + response.DashUrl
+	}
+	if response.HlsUrl != "" {
+		response.HlsUrl = channelUrl + response.HlsUrl
+	}
+	response.Url = response.DashUrl
+	if response.Url == "" {
+		response.Url = response.HlsUrl
+	}
+	response.Expires = expires.UTC()
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Error encoding signed URL", http.StatusInternalServerError)
+		return
+	}
+
+	reqStartTime := r.Context().Value("reqStartTime").(time.Time)
+	reqTook := time.Since(reqStartTime)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Server-Timing", fmt.Sprintf("total;dur=%.3f", reqTook.Seconds()*1000))
+	w.WriteHeader(http.StatusOK)
+
+	w.Write(body)
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+	"github.com/Diniboy1123/manifesto/middleware"
+	"github.com/Diniboy1123/manifesto/models"
+	"github.com/Diniboy1123/manifesto/transformers"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// parseQualityId splits a quality ID like "audio_deu_0" into the stream index name ("audio_deu")
+// and the quality level index (0).
+//
+// If the quality ID is malformed, it returns an error that can be shown to the client.
+func parseQualityId(qualityId string) (string, int, error) {
+	// split from right, because we can have audio_deu_0 where we want audio_deu and 0
+	lastUnderscore := strings.LastIndex(qualityId, "_")
+	if lastUnderscore == -1 || lastUnderscore == len(qualityId)-1 {
+		return "", 0, errors.New("Invalid quality ID format")
+	}
+
+	qualityLevelIndex, err := strconv.Atoi(qualityId[lastUnderscore+1:])
+	if err != nil {
+		return "", 0, errors.New("Invalid quality level index")
+	}
+
+	return qualityId[:lastUnderscore], qualityLevelIndex, nil
+}
+
+// getSiblingUrl returns the absolute URL of a resource next to the requested one, for example
+// the license endpoint of a channel when serving its manifest. The path of the request is kept,
+// so any prefix (like the user's token) is preserved.
+func getSiblingUrl(r *http.Request, name string) string {
+	return getBaseUrl(r) + r.URL.Path[:strings.LastIndex(r.URL.Path, "/")+1] + name
+}
+
+// getBaseUrl returns the scheme and host the client reached the service with, like "https://example.com".
+// Behind a reverse proxy terminating TLS, the scheme is taken from the X-Forwarded-Proto header.
+// It only affects URLs handed back to the same client, so the header doesn't need to be trusted.
+func getBaseUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ","); proto != "" {
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+	}
+	return scheme + "://" + r.Host
+}
+
+// getProfile returns the player compatibility profile to serve a request with, selected by the profile query parameter
+// or by the User-Agent header. The zero Profile is returned if no profile applies, which keeps the default behavior.
+//
+// If the query parameter names an unknown profile, it returns an error that can be shown to the client.
+func getProfile(r *http.Request) (config.Profile, error) {
+	name := r.URL.Query().Get("profile")
+	profile, found := config.Get().GetProfile(name, r.UserAgent())
+	if !found && name != "" {
+		return config.Profile{}, fmt.Errorf("Unknown profile %q", name)
+	}
+	return profile, nil
+}
+
+// getProfileQuery returns the query string to append to the URLs referenced from a manifest, so the following requests
+// of the player are served with the same profile. It is empty unless the profile was selected by the query parameter,
+// as profiles matched by User-Agent apply to every request of the player anyway.
+func getProfileQuery(r *http.Request) string {
+	if name := r.URL.Query().Get("profile"); name != "" {
+		return "?profile=" + name
+	}
+	return ""
+}
+
+// fetchChunk downloads a chunk from the given URL and returns its contents.
+// A cached chunk is reused if it is younger than ttl.
+//
+// If the request fails or the upstream server doesn't respond with 200 OK, it returns an error.
+func fetchChunk(chunkUrl string, ttl time.Duration) ([]byte, error) {
+	chunkReq, err := utils.DoRequestWithTTL("GET", chunkUrl, nil, ttl)
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching chunk: %w", err)
+	}
+	defer chunkReq.Body.Close()
+
+	if chunkReq.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching chunk: %s", chunkReq.Status)
+	}
+
+	chunkData, err := io.ReadAll(chunkReq.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading chunk data: %v", err)
+	}
+	return chunkData, nil
+}
+
+// probeTrackKeyId returns the key ID a quality level is encrypted with by looking at its newest chunk.
+// The oldest chunk of a live timeline may already be gone from the origin, while the newest one is
+// likely requested by players next anyway.
+//
+// Init segments don't come with media data, so if the PlayReady header lists multiple key IDs,
+// we have no other way to tell which one belongs to the track. Chunks without a key ID fall back to the
+// channel's track_key_ids, see transformers.GetTrackKeyId. If the header lists a single key ID
+// (or none), the chunk isn't fetched and nil is returned.
+func probeTrackKeyId(channel config.Channel, protections []models.SmoothProtectionHeader, streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel) ([]byte, error) {
+	keyIds, _, err := utils.ExtractPRKeyIds(protections)
+	if err != nil || len(keyIds) < 2 {
+		return nil, err
+	}
+
+	chunks := streamIndex.GetChunks()
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+
+	chunkUrl := transformers.GetChunkUrl(channel.Url, transformers.GetChunkPath(streamIndex, qualityLevel, chunks[len(chunks)-1].Time))
+	chunkData, err := fetchChunk(chunkUrl, config.Get().SegmentTTL(channel))
+	if err != nil {
+		return nil, err
+	}
+	return transformers.GetTrackKeyId(channel, streamIndex, qualityLevel, chunkData), nil
+}
+
+// prefetchNextChunks fetches the chunks following the one at segmentTime in the background,
+// as configured by the channel's prefetch option. See getNextChunkTimes for how the chunks are picked.
+func prefetchNextChunks(channel config.Channel, streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel, segmentTime uint64) {
+	var urls []string
+	for _, chunkTime := range getNextChunkTimes(streamIndex.GetChunks(), segmentTime, channel.Prefetch) {
+		urls = append(urls, transformers.GetChunkUrl(channel.Url, transformers.GetChunkPath(streamIndex, qualityLevel, chunkTime)))
+	}
+	utils.Prefetch(urls, config.Get().SegmentTTL(channel))
+}
+
+// getNextChunkTimes returns the start times of the count chunks following the one at segmentTime.
+//
+// Players at the live edge request the newest chunk of the timeline, so chunks past its end are predicted
+// from the duration of the last chunk. They may not be available yet, but the manifest may as well lag behind the source.
+// Nothing is returned if segmentTime isn't part of the timeline.
+func getNextChunkTimes(chunks []models.Chunk, segmentTime uint64, count int) []uint64 {
+	for i, chunk := range chunks {
+		if chunk.Time != segmentTime {
+			continue
+		}
+
+		var times []uint64
+		for _, next := range chunks[i+1 : min(i+1+count, len(chunks))] {
+			times = append(times, next.Time)
+		}
+
+		last := chunks[len(chunks)-1]
+		for nextTime := last.Time + last.Duration; len(times) < count && last.Duration > 0; nextTime += last.Duration {
+			times = append(times, nextTime)
+		}
+		return times
+	}
+	return nil
+}
+
+// getExtraPsshBoxes returns the pssh boxes to add to an init segment next to the PlayReady one,
+// based on the DRM systems enabled for the channel.
+//
+// The Widevine pssh box lists every key ID of the PlayReady header (and the key ID of the track), while
+// the W3C common pssh box used for ClearKey only lists the key ID of the track.
+func getExtraPsshBoxes(channel config.Channel, protections []models.SmoothProtectionHeader, keyId []byte) ([]*mp4.PsshBox, error) {
+	var psshBoxes []*mp4.PsshBox
+
+	if channel.Widevine {
+		keyIds, _, err := utils.ExtractPRKeyIds(protections)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(keyIds, func(id []byte) bool { return bytes.Equal(id, keyId) }) {
+			keyIds = append(keyIds, keyId)
+		}
+
+		psshBox, err := utils.NewWidevinePsshBox(keyIds)
+		if err != nil {
+			return nil, err
+		}
+		psshBoxes = append(psshBoxes, psshBox)
+	}
+
+	if channel.ClearKey {
+		psshBox, err := utils.NewCommonPsshBox([][]byte{keyId})
+		if err != nil {
+			return nil, err
+		}
+		psshBoxes = append(psshBoxes, psshBox)
+	}
+
+	return psshBoxes, nil
+}
+
+// cacheTiming returns a Server-Timing entry telling whether the response was served from the processed output cache.
+// It returns an empty string for cache misses, so it can be appended to the other entries as is.
+func cacheTiming(cached bool) string {
+	if cached {
+		return ",cache;desc=hit"
+	}
+	return ""
+}
+
+// logUpstreamStatus annotates the access log of the request with the upstream status implied by err,
+// which is returned by a function fetching data through utils.DoRequest.
+// A nil error means 200 OK, errors other than utils.StatusError leave the status unknown.
+func logUpstreamStatus(r *http.Request, err error) {
+	requestLog := middleware.GetRequestLog(r)
+
+	var statusErr *utils.StatusError
+	switch {
+	case err == nil:
+		requestLog.UpstreamStatus = http.StatusOK
+	case errors.As(err, &statusErr):
+		requestLog.UpstreamStatus = statusErr.StatusCode
+	default:
+		requestLog.UpstreamStatus = 0
+	}
+}
+
+// logCacheResult annotates the access log of the request with whether it was served from the processed output cache.
+func logCacheResult(r *http.Request, cached bool) {
+	if cached {
+		middleware.GetRequestLog(r).Cache = "hit"
+	} else {
+		middleware.GetRequestLog(r).Cache = "miss"
+	}
+}
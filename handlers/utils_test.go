@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/Diniboy1123/manifesto/models"
+)
+
+func TestGetNextChunkTimes(t *testing.T) {
+	chunks := []models.Chunk{
+		{Time: 0, Duration: 20},
+		{Time: 20, Duration: 20},
+		{Time: 40, Duration: 20},
+		{Time: 60, Duration: 15},
+	}
+
+	tests := []struct {
+		name        string
+		segmentTime uint64
+		count       int
+		want        []uint64
+	}{
+		{"listed chunks", 0, 2, []uint64{20, 40}},
+		{"partly predicted", 40, 3, []uint64{60, 75, 90}},
+		{"live edge", 60, 2, []uint64{75, 90}},
+		{"unknown chunk", 30, 2, nil},
+		{"disabled", 0, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getNextChunkTimes(chunks, tt.segmentTime, tt.count); !slices.Equal(got, tt.want) {
+				t.Errorf("getNextChunkTimes(%d, %d) = %v, want %v", tt.segmentTime, tt.count, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSiblingUrl(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/token/stream/g/ch/manifest.mpd", nil)
+	if got := getSiblingUrl(r, "license"); got != "http://example.com/token/stream/g/ch/license" {
+		t.Errorf("Unexpected sibling URL %s", got)
+	}
+
+	r.Header.Set("X-Forwarded-Proto", "https, http")
+	if got := getSiblingUrl(r, "license"); got != "https://example.com/token/stream/g/ch/license" {
+		t.Errorf("Expected the forwarded scheme to be used, got %s", got)
+	}
+
+	r.Header.Set("X-Forwarded-Proto", "javascript")
+	if got := getBaseUrl(r); got != "http://example.com" {
+		t.Errorf("Expected unknown forwarded schemes to be ignored, got %s", got)
+	}
+}
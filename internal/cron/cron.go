@@ -0,0 +1,174 @@
+// Package cron parses the standard 5 field cron expressions (minute, hour, day of month, month, day of week)
+// and computes when they fire next. Fields support *, single values, ranges (1-5), lists (1,3,5)
+// and steps (*/15, 0-30/10). Days of week go from 0 (Sunday) to 6, 7 is accepted as Sunday too.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchDays limits how far Next looks ahead, so impossible dates like February 30 don't loop forever
+const maxSearchDays = 5 * 366
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+	// anyDayOfMonth and anyDayOfWeek record whether the day fields start with *, as a day matches
+	// if either of them matches when both are restricted
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// field describes the allowed range of a cron field.
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses a 5 field cron expression like "30 20 * * 6".
+//
+// If the expression is malformed, it returns an error.
+func Parse(expr string) (*Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("expected %d fields in cron expression %q, got %d", len(fields), expr, len(parts))
+	}
+
+	var bits [5]uint64
+	for i, part := range parts {
+		var err error
+		bits[i], err = parseField(part, fields[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 7 is an alias of Sunday
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+
+	return &Schedule{
+		minutes:       bits[0],
+		hours:         bits[1],
+		daysOfMonth:   bits[2],
+		months:        bits[3],
+		daysOfWeek:    bits[4],
+		anyDayOfMonth: strings.HasPrefix(parts[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// parseField parses a single cron field into a bit set of the matching values.
+func parseField(expr string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepExpr)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepExpr, f.name)
+			}
+		}
+
+		low, high := f.min, f.max
+		if rangeExpr != "*" {
+			lowExpr, highExpr, isRange := strings.Cut(rangeExpr, "-")
+
+			var err error
+			if low, err = parseValue(lowExpr, f); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = parseValue(highExpr, f); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "5/15" means every 15 starting at 5
+				high = f.max
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangeExpr, f.name)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+// parseValue parses a single value of a cron field and checks its range.
+func parseValue(expr string, f field) (int, error) {
+	value, err := strconv.Atoi(expr)
+	if err != nil || value < f.min || value > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, must be between %d and %d", expr, f.name, f.min, f.max)
+	}
+	return value, nil
+}
+
+// Next returns the first time after t the schedule fires at, in the location of t.
+// It returns the zero time if the schedule doesn't fire within the next few years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	// schedules fire at the start of a minute, strictly after t
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for day := 0; day < maxSearchDays; day++ {
+		if s.matchesDay(t) {
+			for current := t; current.Day() == t.Day(); current = current.Add(time.Minute) {
+				if s.matchesTime(current) {
+					return current
+				}
+			}
+		}
+
+		year, month, dayOfMonth := t.Date()
+		t = time.Date(year, month, dayOfMonth+1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// matchesDay reports whether the schedule fires on the day of t.
+func (s *Schedule) matchesDay(t time.Time) bool {
+	if s.months&(1<<int(t.Month())) == 0 {
+		return false
+	}
+
+	dayOfMonth := s.daysOfMonth&(1<<t.Day()) != 0
+	dayOfWeek := s.daysOfWeek&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.anyDayOfMonth && s.anyDayOfWeek:
+		return true
+	case s.anyDayOfMonth:
+		return dayOfWeek
+	case s.anyDayOfWeek:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
+
+// matchesTime reports whether the schedule fires at the hour and minute of t.
+func (s *Schedule) matchesTime(t time.Time) bool {
+	return s.hours&(1<<t.Hour()) != 0 && s.minutes&(1<<t.Minute()) != 0
+}
@@ -0,0 +1,53 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	valid := []string{"* * * * *", "30 20 * * 6", "*/15 8-18 * * 1-5", "0 0 1,15 * *", "5/10 * * * 7"}
+	for _, expr := range valid {
+		if _, err := Parse(expr); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", expr, err)
+		}
+	}
+
+	invalid := []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"}
+	for _, expr := range invalid {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Expected %q to be invalid", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2025, 1, 1, 12, 0, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2025, 1, 1, 12, 1, 0, 0, time.UTC)},
+		{"0 12 * * *", time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)},
+		{"30 20 * * 6", time.Date(2025, 1, 4, 20, 30, 0, 0, time.UTC)},
+		{"0 20 * * 0", time.Date(2025, 1, 5, 20, 0, 0, 0, time.UTC)},
+		{"0 20 * * 7", time.Date(2025, 1, 5, 20, 0, 0, 0, time.UTC)},
+		{"*/20 13 * * *", time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// either day field matches if both are restricted
+		{"0 9 10 * 5", time.Date(2025, 1, 3, 9, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		schedule, err := Parse(test.expr)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", test.expr, err)
+		}
+		if next := schedule.Next(from); !next.Equal(test.expected) {
+			t.Errorf("Expected %q to fire at %v, got %v", test.expr, test.expected, next)
+		}
+	}
+}
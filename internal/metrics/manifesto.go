@@ -0,0 +1,54 @@
+package metrics
+
+var (
+	// HttpRequests counts served requests by route pattern, channel and status code
+	HttpRequests = NewCounterVec(
+		"manifesto_http_requests_total",
+		"Number of HTTP requests served, by route pattern, channel and status code.",
+		"handler", "channel", "code",
+	)
+	// HttpRequestDuration observes how long requests took to serve by route pattern and channel
+	HttpRequestDuration = NewHistogramVec(
+		"manifesto_http_request_duration_seconds",
+		"Time taken to serve HTTP requests, by route pattern and channel.",
+		DefaultBuckets,
+		"handler", "channel",
+	)
+	// HttpResponseBytes counts the bytes of response bodies by route pattern and channel
+	HttpResponseBytes = NewCounterVec(
+		"manifesto_http_response_bytes_total",
+		"Number of response body bytes served, by route pattern and channel.",
+		"handler", "channel",
+	)
+	// UpstreamRequests counts requests sent to upstream servers by status code, "error" if no response was received
+	UpstreamRequests = NewCounterVec(
+		"manifesto_upstream_requests_total",
+		"Number of requests sent to upstream servers, by status code (\"error\" if the request failed).",
+		"code",
+	)
+	// UpstreamRequestDuration observes how long upstream servers took to respond
+	UpstreamRequestDuration = NewHistogramVec(
+		"manifesto_upstream_request_duration_seconds",
+		"Time until upstream servers responded with headers.",
+		DefaultBuckets,
+	)
+	// CacheRequests counts cache lookups by cache ("upstream" responses or "processed" outputs) and result ("hit" or "miss")
+	CacheRequests = NewCounterVec(
+		"manifesto_cache_requests_total",
+		"Number of cache lookups, by cache (upstream responses or processed outputs) and result.",
+		"cache", "result",
+	)
+	// CacheEvictions counts removed cache entries by reason ("expired" or "size")
+	CacheEvictions = NewCounterVec(
+		"manifesto_cache_evictions_total",
+		"Number of entries removed from the cache, by reason (expired or evicted due to the size limit).",
+		"reason",
+	)
+	// SegmentPhaseDuration observes the phases of serving a media segment, as reported in the Server-Timing header
+	SegmentPhaseDuration = NewHistogramVec(
+		"manifesto_segment_phase_duration_seconds",
+		"Time taken by the phases of serving media segments (manifest-fetch, chunk-fetch, init-gen, segment-process).",
+		DefaultBuckets,
+		"phase",
+	)
+)
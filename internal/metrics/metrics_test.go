@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteCounterVec(t *testing.T) {
+	c := &CounterVec{name: "test_total", help: "Test counter.", labelNames: []string{"code"}, values: map[string]*counterValue{}}
+	c.Inc("200")
+	c.Add(2, "200")
+	c.Inc("say \"hi\"")
+
+	var buf bytes.Buffer
+	c.write(&buf)
+
+	expected := "# HELP test_total Test counter.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{code=\"200\"} 3\n" +
+		"test_total{code=\"say \\\"hi\\\"\"} 1\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestWriteHistogramVec(t *testing.T) {
+	h := &HistogramVec{name: "test_seconds", help: "Test histogram.", buckets: []float64{0.1, 1}, values: map[string]*histogramValue{}}
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	var buf bytes.Buffer
+	h.write(&buf)
+
+	expected := "# HELP test_seconds Test histogram.\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{le=\"0.1\"} 2\n" +
+		"test_seconds_bucket{le=\"1\"} 3\n" +
+		"test_seconds_bucket{le=\"+Inf\"} 4\n" +
+		"test_seconds_sum 3.65\n" +
+		"test_seconds_count 4\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+}
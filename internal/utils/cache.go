@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/metrics"
+)
+
+// Default size limit of the memory cache backend if not configured (256 MiB)
+const DEFAULT_CACHE_MAX_SIZE = 256 << 20
+
+// CacheBackend stores the bodies of cached responses.
+// Bookkeeping like expiry and in-use tracking is done by DoRequest, backends only hold the data.
+// Implementations must be safe for concurrent use.
+type CacheBackend interface {
+	// Store reads the body from r and saves it under the given key, replacing any previous body.
+	Store(key string, r io.Reader) error
+	// Open returns a reader for the body stored under the given key.
+	// If there is no body stored under the key (e.g. because it was evicted), it returns an error.
+	Open(key string) (io.ReadCloser, error)
+	// Remove deletes the body stored under the given key, if any.
+	Remove(key string)
+}
+
+var (
+	// cacheBackend is the backend selected by the cache_backend config option
+	cacheBackend CacheBackend
+	// cacheBackendOnce makes sure cacheBackend is only created once
+	cacheBackendOnce sync.Once
+)
+
+// getCacheBackend returns the cache backend selected in the config.
+// The backend is created on first use, so changing it requires a restart.
+func getCacheBackend() CacheBackend {
+	cacheBackendOnce.Do(func() {
+		cfg := config.Get()
+		switch cfg.CacheBackend {
+		case config.CacheBackendMemory:
+			maxSize := int64(cfg.CacheMaxSize)
+			if maxSize <= 0 {
+				maxSize = DEFAULT_CACHE_MAX_SIZE
+			}
+			cacheBackend = NewMemoryCache(maxSize, isCacheEntryUnused)
+		default:
+			cacheBackend = NewDiskCache(cfg.SaveDir)
+		}
+	})
+	return cacheBackend
+}
+
+// isCacheEntryUnused reports whether no response of the cache entry whose body is stored under storageKey is being read.
+// Bodies of entries that were removed from the cache or replaced in the meantime are considered unused.
+func isCacheEntryUnused(storageKey string) bool {
+	// storage keys are the cache key followed by the generation of the entry, see newCacheEntry
+	key := storageKey[:max(strings.LastIndex(storageKey, "#"), 0)]
+	entryAny, found := cache.Load(key)
+	if !found || entryAny.(*cacheEntry).storageKey != storageKey {
+		return true
+	}
+	return entryAny.(*cacheEntry).refCount.Load() <= 0
+}
+
+// DiskCache is a CacheBackend that stores bodies as files in a directory.
+// File names are SHA-1 hashes of the keys.
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache creates a disk cache backend storing its files in dir.
+// The directory is created on the first Store call if it doesn't exist.
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{dir: dir}
+}
+
+// Store writes the body to a file named after the hashed key.
+func (c *DiskCache) Store(key string, r io.Reader) error {
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create saveDir: %w", err)
+	}
+
+	// the body is written to a temporary file first, so readers of a previous body aren't disturbed
+	file, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), c.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+// Open opens the file of the given key.
+func (c *DiskCache) Open(key string) (io.ReadCloser, error) {
+	return os.Open(c.path(key))
+}
+
+// Remove deletes the file of the given key.
+func (c *DiskCache) Remove(key string) {
+	_ = os.Remove(c.path(key))
+}
+
+// path returns the file path for the given key.
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, hashURL(key))
+}
+
+// MemoryCache is a CacheBackend that keeps bodies in memory, bounded by their total size.
+// When the limit is exceeded, the least recently used bodies are evicted first.
+//
+// Bodies that are still in use (as reported by the canEvict callback) are never evicted,
+// so the cache can temporarily exceed its limit while they are being read.
+type MemoryCache struct {
+	mu sync.Mutex
+	// maxSize is the maximum total size of the stored bodies in bytes
+	maxSize int64
+	// size is the current total size of the stored bodies in bytes
+	size int64
+	// items maps keys to their element in lru
+	items map[string]*list.Element
+	// lru holds the stored bodies, most recently used first
+	lru *list.List
+	// canEvict reports whether the body of the given key may be evicted
+	canEvict func(key string) bool
+}
+
+// memoryCacheItem is a body stored in the memory cache.
+type memoryCacheItem struct {
+	key  string
+	data []byte
+}
+
+// NewMemoryCache creates a memory cache backend holding at most maxSize bytes.
+// canEvict is called before evicting a body, return false to keep bodies that are still in use.
+// If canEvict is nil, every body may be evicted.
+func NewMemoryCache(maxSize int64, canEvict func(key string) bool) *MemoryCache {
+	if canEvict == nil {
+		canEvict = func(string) bool { return true }
+	}
+	return &MemoryCache{
+		maxSize:  maxSize,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+		canEvict: canEvict,
+	}
+}
+
+// Store reads the whole body into memory and evicts least recently used bodies to make room for it.
+func (c *MemoryCache) Store(key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+	c.items[key] = c.lru.PushFront(&memoryCacheItem{key: key, data: data})
+	c.size += int64(len(data))
+	c.evict()
+	return nil
+}
+
+// Open returns a reader over the body of the given key and marks it as recently used.
+func (c *MemoryCache) Open(key string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, found := c.items[key]
+	if !found {
+		return nil, fmt.Errorf("%s not found in memory cache", key)
+	}
+	c.lru.MoveToFront(element)
+
+	// the slice is never modified, so readers can keep using it even if the body gets evicted
+	return io.NopCloser(bytes.NewReader(element.Value.(*memoryCacheItem).data)), nil
+}
+
+// Remove deletes the body of the given key.
+func (c *MemoryCache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+}
+
+// Size returns the total size of the stored bodies in bytes.
+func (c *MemoryCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// remove deletes the body of the given key. The caller must hold the lock.
+func (c *MemoryCache) remove(key string) {
+	element, found := c.items[key]
+	if !found {
+		return
+	}
+	c.lru.Remove(element)
+	delete(c.items, key)
+	c.size -= int64(len(element.Value.(*memoryCacheItem).data))
+}
+
+// evict removes least recently used bodies until the size limit is met
+// or only bodies in use are left. The caller must hold the lock.
+func (c *MemoryCache) evict() {
+	for element := c.lru.Back(); element != nil && c.size > c.maxSize; {
+		prev := element.Prev()
+		item := element.Value.(*memoryCacheItem)
+		if c.canEvict(item.key) {
+			c.remove(item.key)
+			metrics.CacheEvictions.Inc("size")
+		}
+		element = prev
+	}
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheEviction(t *testing.T) {
+	inUse := map[string]bool{}
+	c := NewMemoryCache(10, func(key string) bool { return !inUse[key] })
+
+	for _, key := range []string{"a", "b"} {
+		if err := c.Store(key, strings.NewReader("12345")); err != nil {
+			t.Fatalf("Failed to store %s: %v", key, err)
+		}
+	}
+
+	// "a" is now the most recently used, so "b" gets evicted
+	if _, err := c.Open("a"); err != nil {
+		t.Fatalf("Failed to open a: %v", err)
+	}
+	if err := c.Store("c", strings.NewReader("12345")); err != nil {
+		t.Fatalf("Failed to store c: %v", err)
+	}
+	if _, err := c.Open("b"); err == nil {
+		t.Fatalf("Expected b to be evicted")
+	}
+
+	// bodies in use are kept, even if the limit is exceeded
+	inUse["a"] = true
+	inUse["c"] = true
+	inUse["d"] = true
+	if err := c.Store("d", strings.NewReader("12345")); err != nil {
+		t.Fatalf("Failed to store d: %v", err)
+	}
+	if c.Size() != 15 {
+		t.Fatalf("Expected size of 15, got %d", c.Size())
+	}
+
+	body, err := c.Open("a")
+	if err != nil {
+		t.Fatalf("Expected a to be kept: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	if string(data) != "12345" {
+		t.Fatalf("Unexpected body %q", data)
+	}
+}
+
+func TestRemoveReplacedCacheEntry(t *testing.T) {
+	cacheBackendOnce.Do(func() {
+		cacheBackend = NewMemoryCache(DEFAULT_CACHE_MAX_SIZE, isCacheEntryUnused)
+	})
+
+	process := func(output string) func() ([]byte, error) {
+		return func() ([]byte, error) { return []byte(output), nil }
+	}
+
+	if _, _, err := GetOrProcess("replaced", time.Minute, process("old")); err != nil {
+		t.Fatalf("Failed to process: %v", err)
+	}
+	entryAny, _ := cache.Load(processedKeyPrefix + "replaced")
+	stale := entryAny.(*cacheEntry)
+
+	// a call with a shorter TTL replaces the entry, while another one is still about to remove the stale entry
+	if output, cached, err := GetOrProcess("replaced", 0, process("new")); err != nil || cached || string(output) != "new" {
+		t.Fatalf("Expected a fresh output, got %q (cached %v, error %v)", output, cached, err)
+	}
+	removeCacheEntry(processedKeyPrefix+"replaced", stale)
+
+	output, cached, err := GetOrProcess("replaced", time.Minute, process("newer"))
+	if err != nil || !cached || string(output) != "new" {
+		t.Fatalf("Expected the replacing output to be kept, got %q (cached %v, error %v)", output, cached, err)
+	}
+}
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
@@ -9,20 +8,25 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/metrics"
 )
 
 // Default user agent to use for HTTP requests
 const DEFAULT_USER_AGENT = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36"
 
-// cacheEntry represents a cached HTTP response on disk
-// and its associated metadata.
+// cacheEntry represents a cached HTTP response
+// and its associated metadata. The response body is held by the cache backend.
 type cacheEntry struct {
-	// File path where the response is cached
-	filePath string
+	// Key of the response body in the cache backend, unique to this entry
+	storageKey string
+	// Whether the response body was stored in the cache backend
+	stored bool
 	// Error encountered during the request (if any)
 	err error
 	// Timestamp of the last successful request to this URL
@@ -30,16 +34,54 @@ type cacheEntry struct {
 	// Channel to signal when the request is ready
 	ready chan struct{}
 	// Reference count for the number of active requests using this entry, used for cleanup
-	refCount int32
+	refCount atomic.Int32
 	// know when to close channel
 	once sync.Once
+	// Longest cache duration of the requests using this entry, used for cleanup
+	ttl atomic.Int64
+}
+
+// extendTTL raises the cache duration of the entry to ttl if it is longer than the current one.
+func (e *cacheEntry) extendTTL(ttl time.Duration) {
+	for {
+		current := e.ttl.Load()
+		if int64(ttl) <= current || e.ttl.CompareAndSwap(current, int64(ttl)) {
+			return
+		}
+	}
 }
 
 var (
 	// cache is a thread-safe map to store cached responses
 	cache = sync.Map{}
+	// cacheGeneration numbers the cache entries to derive their storage keys from
+	cacheGeneration atomic.Uint64
 )
 
+// newCacheEntry creates an entry for the given cache key that expires after ttl and is in use by its creator.
+//
+// Its body is stored under a key unique to the entry, so removing the body of an entry that was replaced
+// in the meantime never removes the body of the entry replacing it.
+func newCacheEntry(key string, ttl time.Duration) *cacheEntry {
+	entry := &cacheEntry{
+		storageKey: key + "#" + strconv.FormatUint(cacheGeneration.Add(1), 10),
+		ready:      make(chan struct{}),
+		timestamp:  time.Now(),
+	}
+	entry.refCount.Store(1)
+	entry.ttl.Store(int64(ttl))
+	return entry
+}
+
+// removeCacheEntry removes the entry from the cache if it is still stored under the given key,
+// and removes its body from the cache backend.
+func removeCacheEntry(key string, entry *cacheEntry) {
+	cache.CompareAndDelete(key, entry)
+	if entry.stored {
+		getCacheBackend().Remove(entry.storageKey)
+	}
+}
+
 // trackedBody wraps an io.ReadCloser and tracks its usage.
 type trackedBody struct {
 	io.ReadCloser
@@ -55,7 +97,19 @@ func (tb *trackedBody) Close() error {
 	return err
 }
 
-// DoRequest performs an HTTP request and caches the response on disk.
+// StatusError is returned by DoRequest if the upstream server doesn't respond with 200 OK.
+type StatusError struct {
+	// StatusCode is the status code of the upstream response
+	StatusCode int
+	// Status is the status line of the upstream response, e.g. "404 Not Found"
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return "bad status: " + e.Status
+}
+
+// DoRequest performs an HTTP request and caches the response in the configured cache backend.
 // It returns the cached response if available and not expired.
 // If the response is not cached or expired, it performs a new request,
 // caches the response, and returns it.
@@ -64,70 +118,104 @@ func (tb *trackedBody) Close() error {
 // The function is thread-safe and handles concurrent requests to the same URL.
 // The cache is cleaned up periodically based on the configured cache duration.
 func DoRequest(method, url string, headers map[string]string) (*http.Response, error) {
-	cfg := config.Get()
-	cacheDuration := cfg.CacheDuration.Duration()
+	return DoRequestWithTTL(method, url, headers, config.Get().CacheDuration.Duration())
+}
 
+// DoRequestWithTTL works like DoRequest, but uses the given cache duration instead of the configured one.
+// A cached response is only returned if it is younger than ttl, so requests with different TTLs can share
+// the same cache entry: a request with a shorter TTL refreshes it for everyone.
+func DoRequestWithTTL(method, url string, headers map[string]string, ttl time.Duration) (*http.Response, error) {
 	if entryAny, found := cache.Load(url); found {
 		entry := entryAny.(*cacheEntry)
 
 		<-entry.ready
 
-		if entry.err != nil || time.Since(entry.timestamp) >= cacheDuration {
-			cache.Delete(url)
-			if entry.filePath != "" {
-				_ = os.Remove(entry.filePath)
+		if entry.err == nil && time.Since(entry.timestamp) < ttl {
+			entry.extendTTL(ttl)
+			entry.refCount.Add(1)
+			if resp, err := readCachedResponse(entry); err == nil {
+				metrics.CacheRequests.Inc("upstream", "hit")
+				return resp, nil
 			}
-			return fetchAndCacheNewResponse(method, url, headers, nil)
+			// the body was evicted from the cache backend in the meantime
+			entry.refCount.Add(-1)
 		}
 
-		entry.refCount++
-		return readResponseFromFile(entry.filePath, url), nil
+		removeCacheEntry(url, entry)
+		return fetchAndCacheNewResponse(method, url, headers, ttl)
 	}
 
-	return fetchAndCacheNewResponse(method, url, headers, nil)
+	return fetchAndCacheNewResponse(method, url, headers, ttl)
 }
 
-// fetchAndCacheNewResponse is a helper function that performs a new HTTP request,
-// caches the response on disk, and returns the response.
-// It creates a new cache entry if one does not exist.
-// It also handles errors and cleans up the cache entry if the request fails.
-func fetchAndCacheNewResponse(method, url string, headers map[string]string, entry *cacheEntry) (*http.Response, error) {
-	cfg := config.Get()
-	saveDir := cfg.SaveDir
-	if err := os.MkdirAll(saveDir, os.ModePerm); err != nil {
-		return nil, fmt.Errorf("failed to create saveDir: %w", err)
+// DoUncachedRequest performs an HTTP request without caching the response.
+// It is meant for requests that must always reach the upstream server, like license requests.
+// The request is sent through the proxy client with the same default and global headers as DoRequest.
+//
+// The caller is responsible for closing the response body.
+func DoUncachedRequest(method, url string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := newRequest(method, url, body, headers)
+	if err != nil {
+		return nil, err
 	}
 
-	if entry == nil {
-		entry = &cacheEntry{
-			ready:     make(chan struct{}),
-			timestamp: time.Now(),
-			refCount:  1,
-		}
+	return doUpstreamRequest(req)
+}
+
+// doUpstreamRequest sends the request through the proxy client and records its duration and status code.
+func doUpstreamRequest(req *http.Request) (*http.Response, error) {
+	startTime := time.Now()
+	resp, err := GetProxyClient().Do(req)
+	metrics.UpstreamRequestDuration.Observe(time.Since(startTime).Seconds())
+	if err != nil {
+		metrics.UpstreamRequests.Inc("error")
+		return nil, err
+	}
+	metrics.UpstreamRequests.Inc(strconv.Itoa(resp.StatusCode))
+	return resp, nil
+}
+
+// newRequest creates an HTTP request with the default user agent, the configured global headers
+// and the given headers applied in this order, so later ones take precedence.
+func newRequest(method, url string, body io.Reader, headers map[string]string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
 	}
 
+	req.Header.Set("User-Agent", DEFAULT_USER_AGENT)
+	for k, v := range config.Get().GlobalHeaders {
+		req.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return req, nil
+}
+
+// fetchAndCacheNewResponse is a helper function that performs a new HTTP request,
+// caches the response in the cache backend, and returns the response.
+// It creates a new cache entry that expires after ttl, unless a later request extends it.
+// It also handles errors and cleans up the cache entry if the request fails.
+func fetchAndCacheNewResponse(method, url string, headers map[string]string, ttl time.Duration) (*http.Response, error) {
+	entry := newCacheEntry(url, ttl)
+
 	cache.Store(url, entry)
+	metrics.CacheRequests.Inc("upstream", "miss")
 	defer func() {
 		entry.once.Do(func() {
 			close(entry.ready)
 		})
 	}()
 
-	req, err := http.NewRequest(method, url, nil)
+	req, err := newRequest(method, url, nil, headers)
 	if err != nil {
 		setEntryError(url, entry, err)
 		return nil, err
 	}
 
-	req.Header.Set("User-Agent", DEFAULT_USER_AGENT)
-	for k, v := range cfg.GlobalHeaders {
-		req.Header.Set(k, v)
-	}
-	for k, v := range headers {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := GetProxyClient().Do(req)
+	resp, err := doUpstreamRequest(req)
 	if err != nil {
 		setEntryError(url, entry, err)
 		return nil, err
@@ -135,27 +223,24 @@ func fetchAndCacheNewResponse(method, url string, headers map[string]string, ent
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		setEntryError(url, entry, fmt.Errorf("bad status: %s", resp.Status))
+		setEntryError(url, entry, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status})
 		return nil, entry.err
 	}
 
-	filePath := filepath.Join(saveDir, hashURL(url))
-	file, err := os.Create(filePath)
-	if err != nil {
+	if err := getCacheBackend().Store(entry.storageKey, resp.Body); err != nil {
 		setEntryError(url, entry, err)
 		return nil, err
 	}
-	defer file.Close()
 
-	if _, err := io.Copy(file, resp.Body); err != nil {
+	entry.timestamp = time.Now()
+	entry.stored = true
+
+	cachedResp, err := readCachedResponse(entry)
+	if err != nil {
 		setEntryError(url, entry, err)
 		return nil, err
 	}
-
-	entry.timestamp = time.Now()
-	entry.filePath = filePath
-
-	return readResponseFromFile(filePath, url), nil
+	return cachedResp, nil
 }
 
 // setEntryError sets the error for a cache entry and cleans up the cache.
@@ -167,14 +252,14 @@ func fetchAndCacheNewResponse(method, url string, headers map[string]string, ent
 func setEntryError(url string, entry *cacheEntry, err error) {
 	if entry != nil {
 		entry.err = err
-		cache.Delete(url)
+		cache.CompareAndDelete(url, entry)
 
 		entry.once.Do(func() {
 			close(entry.ready)
 		})
 
-		if entry.filePath != "" {
-			_ = os.Remove(entry.filePath)
+		if entry.stored {
+			getCacheBackend().Remove(entry.storageKey)
 		}
 	}
 }
@@ -187,35 +272,30 @@ func hashURL(url string) string {
 	return hex.EncodeToString(h[:])
 }
 
-// readResponseFromFile reads the cached response from the file and returns it as an http.Response.
-// It also decrements the reference count for the cache entry when the response is closed.
-// If the file cannot be opened, it returns an error response.
-func readResponseFromFile(filePath string, url string) *http.Response {
-	f, err := os.Open(filePath)
+// readCachedResponse opens the cached response body of the entry and returns it as an http.Response.
+// The caller must have incremented the reference count of the entry, it is decremented when the response is closed.
+// If the body can't be opened, it returns an error.
+func readCachedResponse(entry *cacheEntry) (*http.Response, error) {
+	body, err := getCacheBackend().Open(entry.storageKey)
 	if err != nil {
-		return &http.Response{
-			StatusCode: 500,
-			Body:       io.NopCloser(bytes.NewReader([]byte("error reading cache file"))),
-		}
+		return nil, err
 	}
 
 	return &http.Response{
 		StatusCode: 200,
 		Body: &trackedBody{
-			ReadCloser: f,
+			ReadCloser: body,
 			onClose: func() {
-				if entryAny, ok := cache.Load(url); ok {
-					entry := entryAny.(*cacheEntry)
-					entry.refCount--
-				}
+				entry.refCount.Add(-1)
 			},
 		},
 		Header: make(http.Header),
-	}
+	}, nil
 }
 
 // StartCleanupLoop starts a goroutine that periodically cleans up the cache.
 // It checks the cache entries and removes any that have expired and are not in use.
+// Entries expire after the longest TTL they were requested with.
 // The cleanup interval is determined by the cache duration configured in the config package.
 //
 // The cleanup loop runs indefinitely until the program exits. Call this function
@@ -232,9 +312,11 @@ func StartCleanupLoop() {
 				url := key.(string)
 				entry := value.(*cacheEntry)
 
-				if time.Since(entry.timestamp) >= cacheDuration && entry.refCount <= 0 {
-					cache.Delete(url)
-					_ = os.Remove(entry.filePath)
+				if time.Since(entry.timestamp) >= time.Duration(entry.ttl.Load()) && entry.refCount.Load() <= 0 {
+					removeCacheEntry(url, entry)
+					if entry.stored {
+						metrics.CacheEvictions.Inc("expired")
+					}
 				}
 				return true
 			})
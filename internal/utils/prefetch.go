@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+)
+
+// Default number of concurrent prefetch requests if not configured
+const DEFAULT_PREFETCH_CONCURRENCY = 4
+
+var (
+	// prefetchSlots limits the number of concurrent prefetch requests
+	prefetchSlots chan struct{}
+	// prefetchSlotsOnce makes sure prefetchSlots is only created once
+	prefetchSlotsOnce sync.Once
+)
+
+// Prefetch fetches the given URLs in the background, so they are already in the cache of DoRequest
+// by the time a client requests them. The responses are cached for ttl.
+//
+// Prefetching is best effort: URLs that are already cached are skipped, failed requests aren't cached, and if all prefetch slots
+// are busy, the remaining URLs are dropped instead of queued, so slow origins can't pile up requests.
+// The number of slots is read from the prefetch_concurrency config option on first use.
+func Prefetch(urls []string, ttl time.Duration) {
+	prefetchSlotsOnce.Do(func() {
+		concurrency := config.Get().PrefetchConcurrency
+		if concurrency <= 0 {
+			concurrency = DEFAULT_PREFETCH_CONCURRENCY
+		}
+		prefetchSlots = make(chan struct{}, concurrency)
+	})
+
+	for _, url := range urls {
+		if _, found := cache.Load(url); found {
+			continue
+		}
+
+		select {
+		case prefetchSlots <- struct{}{}:
+		default:
+			return
+		}
+
+		go func(url string) {
+			defer func() { <-prefetchSlots }()
+
+			resp, err := DoRequestWithTTL("GET", url, nil, ttl)
+			if err != nil {
+				// URLs predicted past the end of a live timeline may not be available yet, which isn't worth logging
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+					log.Printf("Error prefetching %s: %v", url, err)
+				}
+				return
+			}
+			defer resp.Body.Close()
+			_, _ = io.Copy(io.Discard, resp.Body)
+		}(url)
+	}
+}
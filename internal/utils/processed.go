@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/internal/metrics"
+)
+
+// processedKeyPrefix separates keys of processed outputs from the URLs of cached requests
+const processedKeyPrefix = "processed:"
+
+// GetOrProcess returns the output cached under the given key if it is younger than ttl.
+// Otherwise, it calls process, caches its output under the key and returns it.
+// It also reports whether the output was served from the cache.
+//
+// Outputs share the cache backend, cleanup and in-use tracking with DoRequest.
+// Concurrent calls for the same key wait for a single process call instead of processing
+// the same data multiple times. Errors returned by process are not cached.
+func GetOrProcess(key string, ttl time.Duration, process func() ([]byte, error)) ([]byte, bool, error) {
+	key = processedKeyPrefix + key
+
+	for {
+		entry := newCacheEntry(key, ttl)
+
+		entryAny, loaded := cache.LoadOrStore(key, entry)
+		if !loaded {
+			metrics.CacheRequests.Inc("processed", "miss")
+			output, err := processAndCache(key, entry, process)
+			return output, false, err
+		}
+
+		existing := entryAny.(*cacheEntry)
+		<-existing.ready
+
+		if existing.err == nil && time.Since(existing.timestamp) < ttl {
+			existing.extendTTL(ttl)
+			if output, err := readCachedOutput(existing); err == nil {
+				metrics.CacheRequests.Inc("processed", "hit")
+				return output, true, nil
+			}
+		}
+
+		// expired, failed or evicted, try to become the one processing it
+		removeCacheEntry(key, existing)
+	}
+}
+
+// processAndCache calls process and stores its output in the cache backend under the given key.
+// The entry must already be stored in the cache, it is marked as ready when done.
+func processAndCache(key string, entry *cacheEntry, process func() ([]byte, error)) ([]byte, error) {
+	defer func() {
+		entry.once.Do(func() {
+			close(entry.ready)
+		})
+	}()
+
+	output, err := process()
+	if err != nil {
+		setEntryError(key, entry, err)
+		return nil, err
+	}
+
+	if err := getCacheBackend().Store(entry.storageKey, bytes.NewReader(output)); err != nil {
+		// the output is still fine, it just won't be served from the cache
+		setEntryError(key, entry, err)
+		return output, nil
+	}
+
+	entry.timestamp = time.Now()
+	entry.stored = true
+	entry.refCount.Add(-1)
+
+	return output, nil
+}
+
+// readCachedOutput reads the output of the entry from the cache backend.
+func readCachedOutput(entry *cacheEntry) ([]byte, error) {
+	entry.refCount.Add(1)
+	defer entry.refCount.Add(-1)
+
+	body, err := getCacheBackend().Open(entry.storageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// piffSencUUID is the UUID of the PIFF sample encryption box in binary form.
+var piffSencUUID = mustDecodeUUID(mp4.UUIDPiffSenc)
+
+// mustDecodeUUID converts a UUID string to its binary form and panics if it is invalid.
+func mustDecodeUUID(uuid string) []byte {
+	u, err := mp4.NewUUIDFromString(uuid)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// walkBoxes iterates over the MP4 boxes stored in data and calls fn with the type and payload of each box.
+// Iteration stops if fn returns false or if a box header is invalid.
+func walkBoxes(data []byte, fn func(boxType string, payload []byte) bool) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		boxType := string(data[4:8])
+		headerSize := uint64(8)
+
+		switch size {
+		case 0:
+			// box extends to the end of the data
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+
+		if size < headerSize || size > uint64(len(data)) {
+			return
+		}
+
+		if !fn(boxType, data[headerSize:size]) {
+			return
+		}
+		data = data[size:]
+	}
+}
+
+// ExtractKeyIdFromSegment looks for the key ID a media segment was encrypted with.
+// Smooth segments don't carry a tenc box, so the key ID can only be found in the track fragments:
+// either in a PIFF sample encryption box that overrides the default key ID or in a CENC 'seig'
+// sample group description (used for key rotation).
+//
+// If the segment doesn't contain a key ID, it returns nil.
+func ExtractKeyIdFromSegment(data []byte) []byte {
+	var keyId []byte
+	walkBoxes(data, func(boxType string, moof []byte) bool {
+		if boxType != "moof" {
+			return true
+		}
+		walkBoxes(moof, func(boxType string, traf []byte) bool {
+			if boxType != "traf" {
+				return true
+			}
+			walkBoxes(traf, func(boxType string, payload []byte) bool {
+				switch boxType {
+				case "uuid":
+					keyId = extractKeyIdFromPiffSenc(payload)
+				case "sgpd":
+					keyId = extractKeyIdFromSeig(payload)
+				}
+				return keyId == nil
+			})
+			return keyId == nil
+		})
+		return keyId == nil
+	})
+	return keyId
+}
+
+// extractKeyIdFromPiffSenc returns the override key ID of a PIFF sample encryption box payload.
+// The key ID is only present if the first bit of the box flags is set.
+func extractKeyIdFromPiffSenc(payload []byte) []byte {
+	if len(payload) < 20 || !bytes.Equal(payload[:16], piffSencUUID) {
+		return nil
+	}
+	flags := binary.BigEndian.Uint32(payload[16:20]) & 0x00ffffff
+	// AlgorithmID (3 bytes), IV_size (1 byte) and KID (16 bytes) follow the version and flags
+	if flags&0x1 == 0 || len(payload) < 40 {
+		return nil
+	}
+	return bytes.Clone(payload[24:40])
+}
+
+// extractKeyIdFromSeig returns the key ID of the first protected entry of a 'seig' sample group description payload.
+func extractKeyIdFromSeig(payload []byte) []byte {
+	if len(payload) < 12 || string(payload[4:8]) != "seig" {
+		return nil
+	}
+
+	version := payload[0]
+	pos := 8
+	var defaultLength uint32
+	if version == 1 {
+		defaultLength = binary.BigEndian.Uint32(payload[pos : pos+4])
+		pos += 4
+	}
+	if version >= 2 {
+		// default_sample_description_index
+		pos += 4
+	}
+	if len(payload) < pos+4 {
+		return nil
+	}
+	entryCount := binary.BigEndian.Uint32(payload[pos : pos+4])
+	pos += 4
+
+	for i := uint32(0); i < entryCount; i++ {
+		entryLength := uint32(20)
+		if version == 1 {
+			entryLength = defaultLength
+			if entryLength == 0 {
+				if len(payload) < pos+4 {
+					return nil
+				}
+				entryLength = binary.BigEndian.Uint32(payload[pos : pos+4])
+				pos += 4
+			}
+		}
+		if entryLength < 20 || len(payload) < pos+int(entryLength) {
+			return nil
+		}
+
+		// reserved (1 byte), crypt and skip byte block (1 byte), isProtected (1 byte), Per_Sample_IV_Size (1 byte), KID (16 bytes)
+		entry := payload[pos : pos+int(entryLength)]
+		if entry[2] != 0 {
+			return bytes.Clone(entry[4:20])
+		}
+		pos += int(entryLength)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+)
+
+// DefaultSignedUrlTTL is how long signed URLs stay valid if no other duration is requested and signed_url_ttl isn't set
+const DefaultSignedUrlTTL = 24 * time.Hour
+
+// DefaultMaxSignedUrlTTL is the longest duration signed URLs can be valid for if signed_url_max_ttl isn't set
+const DefaultMaxSignedUrlTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrInvalidSignature is returned if a signed URL wasn't signed with the configured key, or for another user or channel
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrSignatureExpired is returned if a signed URL is past its expiry
+	ErrSignatureExpired = errors.New("signature expired")
+	// ErrSignatureTTLTooLong is returned if a signed URL expires further in the future than signed URLs may be valid for
+	ErrSignatureTTLTooLong = errors.New("signature valid for too long")
+)
+
+// SignedUrlTTL returns how long signed URLs stay valid if no other duration is requested, as configured by signed_url_ttl.
+// It never exceeds MaxSignedUrlTTL.
+func SignedUrlTTL() time.Duration {
+	ttl := config.Get().SignedUrlTTL.Duration()
+	if ttl <= 0 {
+		ttl = DefaultSignedUrlTTL
+	}
+	return min(ttl, MaxSignedUrlTTL())
+}
+
+// MaxSignedUrlTTL returns the longest duration signed URLs can be valid for, as configured by signed_url_max_ttl.
+func MaxSignedUrlTTL() time.Duration {
+	if maxTTL := config.Get().SignedUrlMaxTTL.Duration(); maxTTL > 0 {
+		return maxTTL
+	}
+	return DefaultMaxSignedUrlTTL
+}
+
+// SignStreamPath returns the path prefix of a signed URL, which grants the user access to the channel
+// (given as "group/channel") until expires. The prefix looks like "/s/<username>/<expiry>/<signature>"
+// and takes the place of the token in stream URLs, so it is followed by "/stream/<group>/<channel>/...".
+//
+// Init and segment URLs are relative to the manifest, so a player keeps using the signed prefix for the whole session.
+func SignStreamPath(key, username, channel string, expires time.Time) string {
+	expiry := expires.Unix()
+	return fmt.Sprintf("/%s/%s/%d/%s", config.SignedUrlPrefix, url.PathEscape(username), expiry, computeSignature(key, username, channel, expiry))
+}
+
+// VerifyStreamSignature checks the path values of a signed URL against the key, the channel
+// the URL is used for (as "group/channel") and the current time.
+//
+// It returns ErrInvalidSignature if the signature doesn't match and ErrSignatureExpired if it matches, but expired.
+// URLs expiring more than maxTTL after now are rejected with ErrSignatureTTLTooLong, so lowering the limit
+// also revokes URLs that were signed for longer.
+func VerifyStreamSignature(key, username, channel, expiry, signature string, now time.Time, maxTTL time.Duration) error {
+	expiryUnix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	// the comparison takes the same time wherever the signatures differ, so it doesn't leak the expected one
+	if !hmac.Equal([]byte(signature), []byte(computeSignature(key, username, channel, expiryUnix))) {
+		return ErrInvalidSignature
+	}
+
+	if now.Unix() >= expiryUnix {
+		return ErrSignatureExpired
+	}
+	if expiryUnix > now.Add(maxTTL).Unix() {
+		return ErrSignatureTTLTooLong
+	}
+	return nil
+}
+
+// computeSignature returns the URL safe HMAC-SHA256 signature of a signed URL.
+// The fields are separated by line breaks, which can't be part of them in URL paths.
+func computeSignature(key, username, channel string, expiry int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s\n%s\n%d", username, channel, expiry)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyStreamSignature(t *testing.T) {
+	const key = "0123456789abcdef0123456789abcdef"
+	now := time.Unix(1700000000, 0)
+
+	path := SignStreamPath(key, "bob", "testing/live", now.Add(time.Hour))
+	parts := strings.Split(path, "/")
+	if len(parts) != 5 || parts[1] != "s" || parts[2] != "bob" || parts[3] != "1700003600" {
+		t.Fatalf("Unexpected signed path %q", path)
+	}
+	expiry, signature := parts[3], parts[4]
+
+	if err := VerifyStreamSignature(key, "bob", "testing/live", expiry, signature, now, DefaultMaxSignedUrlTTL); err != nil {
+		t.Fatalf("Expected the signature to be valid, got %v", err)
+	}
+
+	for name, verify := range map[string]func() error{
+		"other key": func() error {
+			return VerifyStreamSignature(key+"x", "bob", "testing/live", expiry, signature, now, DefaultMaxSignedUrlTTL)
+		},
+		"other user": func() error {
+			return VerifyStreamSignature(key, "alice", "testing/live", expiry, signature, now, DefaultMaxSignedUrlTTL)
+		},
+		"other channel": func() error {
+			return VerifyStreamSignature(key, "bob", "testing/other", expiry, signature, now, DefaultMaxSignedUrlTTL)
+		},
+		"other expiry": func() error {
+			return VerifyStreamSignature(key, "bob", "testing/live", "1800000000", signature, now, DefaultMaxSignedUrlTTL)
+		},
+		"bad expiry": func() error {
+			return VerifyStreamSignature(key, "bob", "testing/live", "soon", signature, now, DefaultMaxSignedUrlTTL)
+		},
+	} {
+		if err := verify(); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", name, err)
+		}
+	}
+
+	if err := VerifyStreamSignature(key, "bob", "testing/live", expiry, signature, now.Add(time.Hour), DefaultMaxSignedUrlTTL); !errors.Is(err, ErrSignatureExpired) {
+		t.Fatalf("Expected ErrSignatureExpired, got %v", err)
+	}
+
+	// URLs signed for longer than allowed are rejected, even with a valid signature
+	if err := VerifyStreamSignature(key, "bob", "testing/live", expiry, signature, now, 30*time.Minute); !errors.Is(err, ErrSignatureTTLTooLong) {
+		t.Fatalf("Expected ErrSignatureTTLTooLong, got %v", err)
+	}
+}
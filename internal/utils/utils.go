@@ -11,8 +11,10 @@ import (
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
+	"html"
 	"math/big"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 	"unicode/utf16"
@@ -22,41 +24,103 @@ import (
 	"github.com/Eyevinn/mp4ff/mp4"
 )
 
-// PlayReadyRegexp is a regular expression to extract the KID from PlayReady PSSH data.
-// It matches the pattern <KID>...</KID> and captures the base64-encoded KID value.
+const (
+	// UUIDCommonPssh is the system ID of the W3C common PSSH box format, used for ClearKey
+	UUIDCommonPssh = "1077efec-c0b2-4d02-ace3-3c1e52e2fb4b"
+	// UUIDClearKey is the system ID of the DASH-IF ClearKey content protection scheme
+	UUIDClearKey = "e2719d58-a985-b3c9-781a-b030af78d30e"
+)
+
+// PlayReadyRegexp is a regular expression to extract the KIDs from PlayReady PSSH data.
+// It matches both the <KID>...</KID> pattern of v4.0 headers and the <KID VALUE="..."> attribute
+// of v4.1+ headers (the latter can be listed multiple times inside <KIDS>) and captures the base64-encoded KID value.
 // The KID is a 16-byte value used for PlayReady DRM.
-var PlayReadyRegexp = regexp.MustCompile(`<KID>([a-zA-Z0-9+/=]+)</KID>`)
+var PlayReadyRegexp = regexp.MustCompile(`<KID(?:>([a-zA-Z0-9+/=]+)</KID>|\s[^>]*VALUE="([a-zA-Z0-9+/=]+)")`)
+
+// playReadyLaUrlRegexp captures the license server URL of a PlayReady header.
+var playReadyLaUrlRegexp = regexp.MustCompile(`<LA_URL>([^<]*)</LA_URL>`)
 
-// ExtractPRKeyIdFromPssh extracts the PlayReady key ID from the PSSH data.
-// It decodes the PSSH data from UTF-16 and uses a regular expression to find the KID.
-// The KID is then base64-decoded and returned as a byte slice.
-// If the KID is not found or if there is an error during decoding, it returns an error.
+// ExtractPRKeyIdFromPssh extracts the first PlayReady key ID from the PSSH data.
+// See ExtractPRKeyIdsFromPssh for details.
 //
-// The function expects the PSSH data to be in the format defined by PlayReady.
+// If no KID is found, it returns nil.
 func ExtractPRKeyIdFromPssh(data []byte) ([]byte, error) {
-	shorts := make([]uint16, (len(data)-10)/2)
-	for i := range shorts {
-		shorts[i] = uint16(data[10+2*i]) | uint16(data[11+2*i])<<8
-	}
-	decoded := utf16.Decode(shorts)
-	match := PlayReadyRegexp.FindStringSubmatch(string(decoded))
-	if len(match) < 2 {
-		return nil, nil
+	keyIds, err := ExtractPRKeyIdsFromPssh(data)
+	if err != nil || len(keyIds) == 0 {
+		return nil, err
 	}
+	return keyIds[0], nil
+}
 
-	keyBytes, err := base64.StdEncoding.DecodeString(match[1])
-	if err != nil || len(keyBytes) != 16 {
+// ExtractPRKeyIdsFromPssh extracts all PlayReady key IDs from the PSSH data.
+// It decodes the PSSH data from UTF-16 and uses a regular expression to find the KIDs.
+// The KIDs are then base64-decoded, converted from the PlayReady GUID byte order and returned as byte slices.
+// If there is an error during decoding, it returns an error.
+//
+// The function expects the PSSH data to be in the format defined by PlayReady.
+func ExtractPRKeyIdsFromPssh(data []byte) ([][]byte, error) {
+	header, err := decodePlayReadyHeader(data)
+	if err != nil {
 		return nil, err
 	}
 
-	uuid := []byte{
-		keyBytes[3], keyBytes[2], keyBytes[1], keyBytes[0],
-		keyBytes[5], keyBytes[4],
-		keyBytes[7], keyBytes[6],
-		keyBytes[8], keyBytes[9],
-		keyBytes[10], keyBytes[11], keyBytes[12], keyBytes[13], keyBytes[14], keyBytes[15],
+	var keyIds [][]byte
+	for _, match := range PlayReadyRegexp.FindAllStringSubmatch(header, -1) {
+		value := match[1]
+		if value == "" {
+			value = match[2]
+		}
+
+		keyBytes, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyBytes) != 16 {
+			continue
+		}
+
+		uuid := []byte{
+			keyBytes[3], keyBytes[2], keyBytes[1], keyBytes[0],
+			keyBytes[5], keyBytes[4],
+			keyBytes[7], keyBytes[6],
+			keyBytes[8], keyBytes[9],
+			keyBytes[10], keyBytes[11], keyBytes[12], keyBytes[13], keyBytes[14], keyBytes[15],
+		}
+		if !slices.ContainsFunc(keyIds, func(keyId []byte) bool { return bytes.Equal(keyId, uuid) }) {
+			keyIds = append(keyIds, uuid)
+		}
 	}
-	return uuid, nil
+	return keyIds, nil
+}
+
+// ExtractPRLaUrlFromPssh extracts the license server URL from the PlayReady PSSH data.
+//
+// If the header doesn't define one, it returns an empty string.
+func ExtractPRLaUrlFromPssh(data []byte) (string, error) {
+	header, err := decodePlayReadyHeader(data)
+	if err != nil {
+		return "", err
+	}
+
+	match := playReadyLaUrlRegexp.FindStringSubmatch(header)
+	if match == nil {
+		return "", nil
+	}
+	return html.UnescapeString(match[1]), nil
+}
+
+// decodePlayReadyHeader returns the UTF-16 encoded WRM header XML of a PlayReady object.
+// The PlayReady object starts with a 10 byte header, which is skipped.
+func decodePlayReadyHeader(data []byte) (string, error) {
+	if len(data) < 10 {
+		return "", fmt.Errorf("PlayReady object too short")
+	}
+
+	shorts := make([]uint16, (len(data)-10)/2)
+	for i := range shorts {
+		shorts[i] = uint16(data[10+2*i]) | uint16(data[11+2*i])<<8
+	}
+	return string(utf16.Decode(shorts)), nil
 }
 
 // TrimNullBytes trims null bytes from the end of the given byte slice.
@@ -83,18 +147,91 @@ func GeneratePsshData(playreadyProtectionData *models.SmoothProtectionHeader) (s
 		return "", err
 	}
 
-	uuid, err := mp4.NewUUIDFromString(mp4.UUIDPlayReady)
+	psshBox, err := newPsshBox(mp4.UUIDPlayReady, customDataDecoded)
+	if err != nil {
+		return "", err
+	}
+
+	return encodePsshBox(psshBox)
+}
+
+// GenerateWidevineCencHeader builds the Widevine PSSH data (a WidevinePsshData protobuf message) for the given key IDs.
+// Only the algorithm (AESCTR) and key ID fields are set, which is enough for clients to request a license
+// from a license server that knows the keys.
+func GenerateWidevineCencHeader(keyIds [][]byte) []byte {
+	// field 1 (algorithm), varint, AESCTR
+	data := []byte{0x08, 0x01}
+	for _, keyId := range keyIds {
+		// field 2 (key_id), length delimited
+		data = append(data, 0x12, byte(len(keyId)))
+		data = append(data, keyId...)
+	}
+	return data
+}
+
+// NewWidevinePsshBox creates a Widevine PSSH box with PSSH data synthesized from the given key IDs
+// (usually extracted from the PlayReady header).
+func NewWidevinePsshBox(keyIds [][]byte) (*mp4.PsshBox, error) {
+	return newPsshBox(mp4.UUIDWidevine, GenerateWidevineCencHeader(keyIds))
+}
+
+// NewCommonPsshBox creates a W3C common PSSH box listing the given key IDs.
+// The box has no system specific data, it is used by clients to request keys from a ClearKey license server.
+func NewCommonPsshBox(keyIds [][]byte) (*mp4.PsshBox, error) {
+	psshBox, err := newPsshBox(UUIDCommonPssh, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key IDs are only part of version 1 boxes
+	psshBox.Version = 1
+	for _, keyId := range keyIds {
+		psshBox.KIDs = append(psshBox.KIDs, mp4.UUID(keyId))
+	}
+	return psshBox, nil
+}
+
+// GenerateWidevinePsshData generates PSSH data for Widevine DRM.
+// The Widevine PSSH box is created by NewWidevinePsshBox for the given key IDs,
+// encoded to a byte slice and returned as a base64-encoded string.
+func GenerateWidevinePsshData(keyIds [][]byte) (string, error) {
+	psshBox, err := NewWidevinePsshBox(keyIds)
+	if err != nil {
+		return "", err
+	}
+
+	return encodePsshBox(psshBox)
+}
+
+// GenerateCommonPsshData generates W3C common PSSH data for ClearKey.
+// The common PSSH box is created by NewCommonPsshBox for the given key IDs,
+// encoded to a byte slice and returned as a base64-encoded string.
+func GenerateCommonPsshData(keyIds [][]byte) (string, error) {
+	psshBox, err := NewCommonPsshBox(keyIds)
 	if err != nil {
 		return "", err
 	}
 
-	psshBox := &mp4.PsshBox{
+	return encodePsshBox(psshBox)
+}
+
+// newPsshBox creates a version 0 PSSH box for the given system ID with the given system specific data.
+func newPsshBox(systemId string, data []byte) (*mp4.PsshBox, error) {
+	uuid, err := mp4.NewUUIDFromString(systemId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mp4.PsshBox{
 		Version:  0,
 		Flags:    0,
 		SystemID: uuid,
-		Data:     customDataDecoded,
-	}
+		Data:     data,
+	}, nil
+}
 
+// encodePsshBox encodes the given PSSH box and returns it as a base64-encoded string.
+func encodePsshBox(psshBox *mp4.PsshBox) (string, error) {
 	psshDataBytes := bytes.NewBuffer(nil)
 	if err := psshBox.Encode(psshDataBytes); err != nil {
 		return "", err
@@ -103,25 +240,68 @@ func GeneratePsshData(playreadyProtectionData *models.SmoothProtectionHeader) (s
 	return base64.StdEncoding.EncodeToString(psshDataBytes.Bytes()), nil
 }
 
-// ExtractKeyInfo extracts the key ID, key, and PSSH data from the provided protections and channel.
-// It checks for the PlayReady system ID and decodes the PSSH data.
-// If the key ID is found, it retrieves the key from the channel.
+// ExtractPRKeyIds extracts the PlayReady PSSH data and every key ID it lists from the provided protections.
+// The PSSH data is returned with trailing null bytes trimmed.
 //
-// If the key is not found, it returns an error.
-func ExtractKeyInfo(protections []models.SmoothProtectionHeader, channel config.Channel) (keyId, key, pssh []byte, err error) {
+// If the protections don't contain PlayReady data, it returns nil values and no error.
+func ExtractPRKeyIds(protections []models.SmoothProtectionHeader) (keyIds [][]byte, pssh []byte, err error) {
 	for _, prot := range protections {
 		if strings.ToLower(prot.SystemID) == mp4.UUIDPlayReady {
 			pssh, err = base64.StdEncoding.DecodeString(prot.CustomData)
 			if err != nil {
-				return nil, nil, nil, fmt.Errorf("error decoding PSSH: %w", err)
+				return nil, nil, fmt.Errorf("error decoding PSSH: %w", err)
 			}
 			pssh = TrimNullBytes(pssh)
 
-			keyId, err = ExtractPRKeyIdFromPssh(pssh)
+			keyIds, err = ExtractPRKeyIdsFromPssh(pssh)
 			if err != nil {
-				return nil, nil, nil, fmt.Errorf("error extracting key ID: %w", err)
+				return nil, nil, fmt.Errorf("error extracting key ID: %w", err)
 			}
-			break
+			return keyIds, pssh, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// ExtractKeyInfo extracts the key ID, key, and PSSH data of a single track from the provided protections and channel.
+// It checks for the PlayReady system ID and decodes the PSSH data.
+//
+// Providers may protect tracks with different keys (e.g. separate keys for audio, video and UHD qualities)
+// and list all of them in the PlayReady header. The key ID of the track is resolved in the following order:
+//   - trackKeyId, the key ID the track's segments are encrypted with, if known from the segments or the channel config
+//   - the only key ID listed in the PlayReady header
+//   - the only key ID listed in the PlayReady header that the channel has a key for
+//   - the first key ID listed in the PlayReady header, if the channel has a key for none of them
+//
+// If the channel has keys for several of the listed key IDs, the key ID of the track can't be told
+// without trackKeyId, so it returns an error instead of guessing.
+// If the key ID is found, it retrieves the key from the channel.
+// If the channel has no keys, the key is nil. If the channel has keys, but not the one for the key ID, it returns an error.
+func ExtractKeyInfo(protections []models.SmoothProtectionHeader, channel config.Channel, trackKeyId []byte) (keyId, key, pssh []byte, err error) {
+	keyIds, pssh, err := ExtractPRKeyIds(protections)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	switch {
+	case trackKeyId != nil:
+		keyId = trackKeyId
+	case len(keyIds) == 1:
+		keyId = keyIds[0]
+	case len(keyIds) > 1:
+		var keyedIds [][]byte
+		for _, candidate := range keyIds {
+			if candidateKey, err := channel.GetKey(candidate); err == nil && len(candidateKey) > 0 {
+				keyedIds = append(keyedIds, candidate)
+			}
+		}
+		switch len(keyedIds) {
+		case 0:
+			keyId = keyIds[0]
+		case 1:
+			keyId = keyedIds[0]
+		default:
+			return nil, nil, nil, fmt.Errorf("PlayReady header lists %d key IDs with a configured key, unable to tell which one the track uses, configure track_key_ids", len(keyedIds))
 		}
 	}
 
@@ -129,6 +309,11 @@ func ExtractKeyInfo(protections []models.SmoothProtectionHeader, channel config.
 		return nil, nil, nil, fmt.Errorf("no PlayReady key ID found")
 	}
 
+	// channels without keys are served encrypted, so only the key ID and PSSH data are needed
+	if len(channel.Keys) == 0 {
+		return keyId, nil, pssh, nil
+	}
+
 	key, err = channel.GetKey(keyId)
 	if err != nil {
 		if err.Error() == "key not found" && channel.Keys != nil {
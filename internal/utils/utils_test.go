@@ -1,9 +1,16 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"testing"
+	"unicode/utf16"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/models"
+	"github.com/Eyevinn/mp4ff/mp4"
 )
 
 func TestExtractPRKeyIdFromPssh(t *testing.T) {
@@ -26,3 +33,154 @@ func TestExtractPRKeyIdFromPssh(t *testing.T) {
 	}
 	t.Logf("Extracted key ID: %s", hex.EncodeToString(keyId))
 }
+
+func TestExtractKeyInfoWithoutKeys(t *testing.T) {
+	header := `<WRMHEADER xmlns="http://schemas.microsoft.com/DRM/2007/03/PlayReadyHeader" version="4.0.0.0"><DATA>` +
+		`<KID>4Rplb+TbNES8tGkNFWTEHA==</KID></DATA></WRMHEADER>`
+
+	pro := make([]byte, 10)
+	for _, short := range utf16.Encode([]rune(header)) {
+		pro = binary.LittleEndian.AppendUint16(pro, short)
+	}
+	protections := []models.SmoothProtectionHeader{{
+		SystemID:   mp4.UUIDPlayReady,
+		CustomData: base64.StdEncoding.EncodeToString(pro),
+	}}
+
+	// channels without keys are served encrypted, so only the key ID and the PSSH data are returned
+	keyId, key, pssh, err := ExtractKeyInfo(protections, config.Channel{}, nil)
+	if err != nil {
+		t.Fatalf("Failed to extract key info: %v", err)
+	}
+	if hex.EncodeToString(keyId) != "6f651ae1dbe44434bcb4690d1564c41c" || key != nil || len(pssh) == 0 {
+		t.Fatalf("Unexpected key info: key ID %x, key %x, %d bytes of PSSH data", keyId, key, len(pssh))
+	}
+
+	// channels with keys must have the one of the track
+	_, _, _, err = ExtractKeyInfo(protections, config.Channel{Keys: []string{"000102030405060708090a0b0c0d0e0f:000102030405060708090a0b0c0d0e0f"}}, nil)
+	if err == nil {
+		t.Fatalf("Expected an error for a missing key")
+	}
+}
+
+func TestExtractPRKeyIdsFromPssh(t *testing.T) {
+	header := `<WRMHEADER xmlns="http://schemas.microsoft.com/DRM/2007/03/PlayReadyHeader" version="4.3.0.0"><DATA><PROTECTINFO><KIDS>` +
+		`<KID ALGID="AESCTR" VALUE="4Rplb+TbNES8tGkNFWTEHA=="></KID>` +
+		`<KID ALGID="AESCTR" VALUE="AAECAwQFBgcICQoLDA0ODw=="></KID>` +
+		`</KIDS></PROTECTINFO></DATA></WRMHEADER>`
+
+	// 10 bytes of PlayReady object and record headers, followed by the UTF-16LE encoded header
+	pro := make([]byte, 10)
+	for _, short := range utf16.Encode([]rune(header)) {
+		pro = binary.LittleEndian.AppendUint16(pro, short)
+	}
+
+	keyIds, err := ExtractPRKeyIdsFromPssh(pro)
+	if err != nil {
+		t.Fatalf("Failed to extract key IDs: %v", err)
+	}
+
+	expectedKeyIds := []string{"6f651ae1dbe44434bcb4690d1564c41c", "030201000504070608090a0b0c0d0e0f"}
+	if len(keyIds) != len(expectedKeyIds) {
+		t.Fatalf("Expected %d key IDs, got %d", len(expectedKeyIds), len(keyIds))
+	}
+	for i, keyId := range keyIds {
+		if hex.EncodeToString(keyId) != expectedKeyIds[i] {
+			t.Fatalf("Expected key ID %s, got %s", expectedKeyIds[i], hex.EncodeToString(keyId))
+		}
+	}
+}
+
+func TestExtractKeyInfoWithSeveralKeyIds(t *testing.T) {
+	header := `<WRMHEADER xmlns="http://schemas.microsoft.com/DRM/2007/03/PlayReadyHeader" version="4.3.0.0"><DATA><PROTECTINFO><KIDS>` +
+		`<KID ALGID="AESCTR" VALUE="4Rplb+TbNES8tGkNFWTEHA=="></KID>` +
+		`<KID ALGID="AESCTR" VALUE="AAECAwQFBgcICQoLDA0ODw=="></KID>` +
+		`</KIDS></PROTECTINFO></DATA></WRMHEADER>`
+
+	pro := make([]byte, 10)
+	for _, short := range utf16.Encode([]rune(header)) {
+		pro = binary.LittleEndian.AppendUint16(pro, short)
+	}
+	protections := []models.SmoothProtectionHeader{{
+		SystemID:   mp4.UUIDPlayReady,
+		CustomData: base64.StdEncoding.EncodeToString(pro),
+	}}
+
+	firstKey := "6f651ae1dbe44434bcb4690d1564c41c:000102030405060708090a0b0c0d0e0f"
+	secondKey := "030201000504070608090a0b0c0d0e0f:0f0e0d0c0b0a09080706050403020100"
+
+	// the only key ID with a key is picked
+	keyId, key, _, err := ExtractKeyInfo(protections, config.Channel{Keys: []string{secondKey}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to extract key info: %v", err)
+	}
+	if hex.EncodeToString(keyId) != "030201000504070608090a0b0c0d0e0f" || hex.EncodeToString(key) != "0f0e0d0c0b0a09080706050403020100" {
+		t.Fatalf("Unexpected key info: key ID %x, key %x", keyId, key)
+	}
+
+	// with keys for both key IDs, only the key ID of the track tells them apart
+	channel := config.Channel{Keys: []string{firstKey, secondKey}}
+	if _, _, _, err := ExtractKeyInfo(protections, channel, nil); err == nil {
+		t.Fatalf("Expected an error for ambiguous key IDs")
+	}
+
+	trackKeyId, _ := hex.DecodeString("6f651ae1dbe44434bcb4690d1564c41c")
+	keyId, key, _, err = ExtractKeyInfo(protections, channel, trackKeyId)
+	if err != nil {
+		t.Fatalf("Failed to extract key info: %v", err)
+	}
+	if !bytes.Equal(keyId, trackKeyId) || hex.EncodeToString(key) != "000102030405060708090a0b0c0d0e0f" {
+		t.Fatalf("Unexpected key info: key ID %x, key %x", keyId, key)
+	}
+}
+
+func TestExtractPRLaUrlFromPssh(t *testing.T) {
+	header := `<WRMHEADER xmlns="http://schemas.microsoft.com/DRM/2007/03/PlayReadyHeader" version="4.0.0.0"><DATA>` +
+		`<KID>4Rplb+TbNES8tGkNFWTEHA==</KID><LA_URL>https://license.example.com/rightsmanager.asmx?a=1&amp;b=2</LA_URL>` +
+		`</DATA></WRMHEADER>`
+
+	pro := make([]byte, 10)
+	for _, short := range utf16.Encode([]rune(header)) {
+		pro = binary.LittleEndian.AppendUint16(pro, short)
+	}
+
+	laUrl, err := ExtractPRLaUrlFromPssh(pro)
+	if err != nil {
+		t.Fatalf("Failed to extract LA_URL: %v", err)
+	}
+	if laUrl != "https://license.example.com/rightsmanager.asmx?a=1&b=2" {
+		t.Fatalf("Unexpected LA_URL %q", laUrl)
+	}
+}
+
+func TestExtractKeyIdFromSegment(t *testing.T) {
+	box := func(boxType string, payload ...[]byte) []byte {
+		data := bytes.Join(payload, nil)
+		return append(binary.BigEndian.AppendUint32(nil, uint32(8+len(data))), append([]byte(boxType), data...)...)
+	}
+
+	keyId, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	piffSenc := box("uuid", piffSencUUID, []byte{0, 0, 0, 1}, []byte{0, 0, 1, 8}, keyId, []byte{0, 0, 0, 0})
+	seig := box("sgpd", []byte{1, 0, 0, 0}, []byte("seig"), []byte{0, 0, 0, 20}, []byte{0, 0, 0, 1}, []byte{0, 0, 1, 8}, keyId)
+
+	for name, traf := range map[string][]byte{"piff senc": piffSenc, "seig": seig} {
+		segment := append(box("moof", box("mfhd", make([]byte, 8)), box("traf", box("tfhd", make([]byte, 8)), traf)), box("mdat")...)
+		if got := ExtractKeyIdFromSegment(segment); !bytes.Equal(got, keyId) {
+			t.Fatalf("%s: expected key ID %x, got %x", name, keyId, got)
+		}
+	}
+
+	unprotected := box("moof", box("traf", box("tfhd", make([]byte, 8))))
+	if got := ExtractKeyIdFromSegment(unprotected); got != nil {
+		t.Fatalf("Expected no key ID, got %x", got)
+	}
+}
+
+func TestGenerateWidevineCencHeader(t *testing.T) {
+	keyId, _ := hex.DecodeString("6f651ae1dbe44434bcb4690d1564c41c")
+	expected := "0801" + "1210" + "6f651ae1dbe44434bcb4690d1564c41c"
+
+	if got := hex.EncodeToString(GenerateWidevineCencHeader([][]byte{keyId})); got != expected {
+		t.Fatalf("Expected Widevine PSSH data %s, got %s", expected, got)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"log"
+	"os"
 
 	"github.com/Diniboy1123/manifesto/config"
 	"github.com/Diniboy1123/manifesto/internal/utils"
@@ -10,6 +11,32 @@ import (
 )
 
 func main() {
+	// subcommands run standalone and don't need a config file
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "convert":
+			if err := runConvert(os.Args[2:]); err != nil {
+				log.Fatalf("convert: %v", err)
+			}
+			return
+		case "probe":
+			if err := runProbe(os.Args[2:]); err != nil {
+				log.Fatalf("probe: %v", err)
+			}
+			return
+		case "record":
+			if err := runRecord(os.Args[2:]); err != nil {
+				log.Fatalf("record: %v", err)
+			}
+			return
+		case "sign":
+			if err := runSign(os.Args[2:]); err != nil {
+				log.Fatalf("sign: %v", err)
+			}
+			return
+		}
+	}
+
 	configPath := flag.String("config", "config.json", "Path to the configuration file")
 
 	flag.Parse()
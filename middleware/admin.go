@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Diniboy1123/manifesto/config"
+)
+
+// AdminMiddleware only lets requests of admin users through. It must run after AuthMiddleware.
+// Without users configured there is no way to tell admins apart, so every request is rejected.
+// If the user isn't an admin, it returns a 403 Forbidden response.
+func AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := r.Context().Value("user").(*config.User)
+		if user == nil || !user.Admin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -3,8 +3,10 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
 )
 
 // AuthMiddleware handles user authentication based on the provided token in the URL.
@@ -12,6 +14,9 @@ import (
 // If no users are configured, it allows access without authentication.
 // If the token is missing or invalid, it returns a 401 Unauthorized response.
 //
+// Requests to signed URLs (see utils.SignStreamPath) are authenticated by their signature instead,
+// which must be valid for the user, the requested channel and not be expired.
+//
 // The user information is stored in the request context under the key "user".
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -23,18 +28,19 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		token := r.PathValue("token")
-		if token == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		var user *config.User
+		if signature := r.PathValue("signature"); signature != "" {
+			user = getSignedUser(r, signature)
+		} else if token := r.PathValue("token"); token != "" {
+			user = getUser(token)
 		}
-
-		user := getUser(token)
 		if user == nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
+		GetRequestLog(r).User = user.Username
+
 		ctx := context.WithValue(r.Context(), "user", user)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -52,3 +58,31 @@ func getUser(token string) *config.User {
 	}
 	return nil
 }
+
+// getSignedUser verifies the signature of a signed URL and retrieves the user it was signed for.
+// If the signature is invalid, expired or the user doesn't exist anymore, it returns nil.
+func getSignedUser(r *http.Request, signature string) *config.User {
+	cfg := config.Get()
+	// the key may have been removed since the routes were set up
+	if cfg.UrlSigningKey == "" {
+		return nil
+	}
+
+	// recordings are served in place of a channel group
+	channel := r.PathValue("groupId") + "/" + r.PathValue("channelId")
+	if recordingId := r.PathValue("recordingId"); recordingId != "" {
+		channel = config.RecordingsGroup + "/" + recordingId
+	}
+
+	username := r.PathValue("user")
+	if err := utils.VerifyStreamSignature(cfg.UrlSigningKey, username, channel, r.PathValue("expires"), signature, time.Now(), utils.MaxSignedUrlTTL()); err != nil {
+		return nil
+	}
+
+	for _, user := range cfg.Users {
+		if user.Username == username {
+			return &user
+		}
+	}
+	return nil
+}
@@ -8,14 +8,14 @@ import (
 )
 
 // ChannelMiddleware extracts the channel ID from the request URL and retrieves the corresponding channel configuration.
-// It checks if the request method is GET or HEAD and validates the channel ID.
+// It checks if the request method is GET, HEAD or POST (used for license requests) and validates the channel ID.
 // If the channel ID is not found or invalid, it returns a 404 Not Found error.
 // If the channel is found, it stores the channel in the request context and calls the next handler.
 //
 // The channel information is stored in the request context under the key "channel".
 func ChannelMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
@@ -9,6 +9,9 @@ import (
 // CorsMiddleware adds CORS headers to the response.
 // It allows requests from any origin and sets the "X-Powered-By" header to "manifesto".
 // This middleware should be used for all HTTP handlers to enable CORS support.
+//
+// Preflight (OPTIONS) requests are answered directly, without calling the next handler,
+// as browsers send them before license requests and don't include credentials.
 func CorsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Note: For sure not the most ideal middleware for this, but it's the first one
@@ -19,6 +22,16 @@ func CorsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 
 		w.Header().Set("X-Powered-By", "manifesto")
 
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, DELETE, OPTIONS")
+			if requestHeaders := r.Header.Get("Access-Control-Request-Headers"); requestHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requestHeaders)
+			}
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
 		next(w, r.WithContext(ctx))
 	}
 }
@@ -2,12 +2,14 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -33,46 +35,168 @@ var (
 	logWorkerDone chan struct{}
 )
 
-// LogRequestMiddleware logs incoming HTTP requests.
-// It logs the client's IP address, user agent, request path, and user information (if available).
+// RequestLog holds the details of a request that are only known further down the chain.
+// Middlewares and handlers annotate it via GetRequestLog, and LogRequestMiddleware writes it
+// to the access log once the response is complete.
+type RequestLog struct {
+	// User is the name of the authenticated user, if any
+	User string
+	// UpstreamStatus is the status code of the upstream response the request depended on, 0 if unknown
+	UpstreamStatus int
+	// Cache is "hit" if the response was served from the cache, "miss" if it had to be generated, empty if not cacheable
+	Cache string
+}
+
+// GetRequestLog returns the access log record of the request.
+// If the request isn't logged, it returns a throwaway record, so callers never have to check for nil.
+func GetRequestLog(r *http.Request) *RequestLog {
+	if requestLog, ok := r.Context().Value("requestLog").(*RequestLog); ok {
+		return requestLog
+	}
+	return &RequestLog{}
+}
+
+// LogRequestMiddleware logs HTTP requests after they were served.
+// Each request results in a single access log line with the client's IP address, user agent, method, path,
+// response status, response size and duration. The user, channel, representation, segment time, upstream status
+// and cache result are included as well if known. Tokens in the path are masked.
+//
+// Lines are written as logfmt or JSON, depending on the configured log format.
 // It also handles log file rotation based on the configured log path.
 // The log file is created if it doesn't exist, and the log entries are appended to it.
-// The log entries are formatted with a timestamp and the relevant request information.
 // This middleware is thread-safe and can handle concurrent requests by using a buffered channel.
 //
 // The log file is closed when the server shuts down or when the log path changes.
 func LogRequestMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+
+		requestLog := &RequestLog{}
+		recorder := &statusRecorder{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), "requestLog", requestLog)
+
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
 		ip, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
 			ip = r.RemoteAddr
 		}
 
-		ua := r.UserAgent()
-		token := r.PathValue("token")
 		path := r.URL.Path
-		if token != "" {
+		if token := r.PathValue("token"); token != "" {
 			path = strings.Replace(path, token, "***", 1)
 		}
+		// signatures grant access until they expire, just like tokens
+		if signature := r.PathValue("signature"); signature != "" {
+			path = strings.Replace(path, signature, "***", 1)
+		}
 
-		u := r.Context().Value("user")
-		userInfo := ""
-		if u != nil {
-			userInfo = " user=" + u.(*config.User).Username
+		var channel string
+		if r.PathValue("groupId") != "" && r.PathValue("channelId") != "" {
+			channel = r.PathValue("groupId") + "/" + r.PathValue("channelId")
 		}
 
-		logLine := fmt.Sprintf("IP=%s%s path=%q user-agent=%q", ip, userInfo, path, ua)
+		fields := []logField{
+			{"time", startTime.Format(time.RFC3339Nano)},
+			{"ip", ip},
+			{"user", requestLog.User},
+			{"method", r.Method},
+			{"path", path},
+			{"status", recorder.statusCode()},
+			{"bytes", recorder.bytes},
+			{"duration_ms", float64(time.Since(startTime).Microseconds()) / 1000},
+			{"channel", channel},
+			{"representation", r.PathValue("qualityId")},
+			{"segment_time", r.PathValue("time")},
+			{"upstream_status", requestLog.UpstreamStatus},
+			{"cache", requestLog.Cache},
+			{"user_agent", r.UserAgent()},
+		}
+
+		var logLine string
+		if config.Get().LogFormat == config.LogFormatJson {
+			logLine = formatJsonLine(fields)
+		} else {
+			logLine = formatLogfmtLine(fields)
+		}
 
 		select {
 		case logChan <- logLine:
 		default:
 			fmt.Fprintln(os.Stderr, "log channel full, dropping log")
 		}
-
-		next.ServeHTTP(w, r)
 	})
 }
 
+// logField is a single key-value pair of an access log line.
+// Empty strings and zero numbers are omitted from the line.
+type logField struct {
+	key   string
+	value any
+}
+
+// isEmpty reports whether the field should be omitted.
+func (f logField) isEmpty() bool {
+	switch v := f.value.(type) {
+	case string:
+		return v == ""
+	case int:
+		return v == 0
+	}
+	return false
+}
+
+// formatLogfmtLine renders the fields as key=value pairs. String values are quoted if needed.
+func formatLogfmtLine(fields []logField) string {
+	var sb strings.Builder
+	for _, field := range fields {
+		if field.isEmpty() {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(field.key)
+		sb.WriteByte('=')
+
+		switch v := field.value.(type) {
+		case string:
+			if v == "" || strings.ContainsAny(v, " \"=\\") || strconv.QuoteToASCII(v) != `"`+v+`"` {
+				sb.WriteString(strconv.Quote(v))
+			} else {
+				sb.WriteString(v)
+			}
+		default:
+			fmt.Fprint(&sb, v)
+		}
+	}
+	return sb.String()
+}
+
+// formatJsonLine renders the fields as a JSON object, keeping their order.
+func formatJsonLine(fields []logField) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for _, field := range fields {
+		if field.isEmpty() {
+			continue
+		}
+		if sb.Len() > 1 {
+			sb.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			value = []byte("null")
+		}
+		sb.Write(key)
+		sb.WriteByte(':')
+		sb.Write(value)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
 // InitLogger initializes the logger and starts a background goroutine to process log messages.
 // It creates a buffered channel for log messages and periodically checks for log file rotation.
 // Log messages are written to the current log file and standard output.
@@ -133,12 +257,13 @@ func flushAndClose() {
 // writeLog writes a log message to the current log file and standard output.
 // It uses the current logger instance to write the log message if available.
 // The log message is also printed to the standard output for visibility.
+// Access log lines carry their own timestamp, so they are written without the usual log prefix.
 func writeLog(line string) {
 	logMu.RLock()
 	logger := currLogger
 	logMu.RUnlock()
 
-	log.Println(line)
+	fmt.Fprintln(log.Writer(), line)
 	if logger != nil {
 		logger.Println(line)
 	}
@@ -172,7 +297,7 @@ func checkLogPath() {
 				log.Printf("Warning: could not open log file %s: %v", logPath, err)
 			} else {
 				currFile = file
-				currLogger = log.New(file, "", log.LstdFlags)
+				currLogger = log.New(file, "", 0)
 			}
 		}
 	}
@@ -0,0 +1,25 @@
+package middleware
+
+import "testing"
+
+func TestFormatLogLines(t *testing.T) {
+	fields := []logField{
+		{"ip", "127.0.0.1"},
+		{"user", ""},
+		{"path", "/stream/g/c/manifest.mpd"},
+		{"status", 404},
+		{"upstream_status", 0},
+		{"duration_ms", 1.5},
+		{"user_agent", "VLC/3.0.20 \"test\""},
+	}
+
+	expected := `ip=127.0.0.1 path=/stream/g/c/manifest.mpd status=404 duration_ms=1.5 user_agent="VLC/3.0.20 \"test\""`
+	if line := formatLogfmtLine(fields); line != expected {
+		t.Errorf("Unexpected logfmt line: %s", line)
+	}
+
+	expected = `{"ip":"127.0.0.1","path":"/stream/g/c/manifest.mpd","status":404,"duration_ms":1.5,"user_agent":"VLC/3.0.20 \"test\""}`
+	if line := formatJsonLine(fields); line != expected {
+		t.Errorf("Unexpected JSON line: %s", line)
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/metrics"
+)
+
+// MetricsMiddleware records the number, duration, status codes and response sizes of requests.
+// Requests are labeled by their route pattern (so the values of path parameters don't create new series)
+// and by their channel as "groupId/channelId". Requests for unknown channels get an empty channel label.
+//
+// It should be the outermost middleware, so rejected requests are counted as well.
+func MetricsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next(recorder, r)
+
+		var channelLabel string
+		if _, ok := config.Get().GetChannel(r.PathValue("groupId"), r.PathValue("channelId")); ok {
+			channelLabel = r.PathValue("groupId") + "/" + r.PathValue("channelId")
+		}
+
+		metrics.HttpRequests.Inc(r.Pattern, channelLabel, strconv.Itoa(recorder.statusCode()))
+		metrics.HttpRequestDuration.Observe(time.Since(startTime).Seconds(), r.Pattern, channelLabel)
+		metrics.HttpResponseBytes.Add(float64(recorder.bytes), r.Pattern, channelLabel)
+	}
+}
@@ -95,10 +95,13 @@ type Representation struct {
 }
 
 type Descriptor struct {
-	Value       string `xml:"value,attr,omitempty"`
-	SchemeIDURI string `xml:"schemeIdUri,attr"`
-	Pro         *Pro   `xml:"mspr:pro,omitempty"`
-	Pssh        *Pssh  `xml:"cenc:pssh,omitempty"`
+	Value       string       `xml:"value,attr,omitempty"`
+	SchemeIDURI string       `xml:"schemeIdUri,attr"`
+	DefaultKID  string       `xml:"cenc:default_KID,attr,omitempty"`
+	Pro         *Pro         `xml:"mspr:pro,omitempty"`
+	LaUrl       *LaUrl       `xml:"mspr:la_url,omitempty"`
+	Pssh        *Pssh        `xml:"cenc:pssh,omitempty"`
+	DashIfLaUrl *DashIfLaUrl `xml:"dashif:laurl,omitempty"`
 }
 
 type SegmentTemplate struct {
@@ -126,6 +129,15 @@ type Pro struct {
 	Data  string `xml:",chardata"`
 }
 
+type LaUrl struct {
+	Data string `xml:",chardata"`
+}
+
+type DashIfLaUrl struct {
+	XMLNS string `xml:"xmlns:dashif,attr"`
+	Data  string `xml:",chardata"`
+}
+
 type Pssh struct {
 	XMLNS string `xml:"xmlns:cenc,attr"`
 	Data  string `xml:",chardata"`
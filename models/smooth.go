@@ -2,10 +2,15 @@ package models
 
 import (
 	"encoding/xml"
+	"fmt"
 	"io"
 	"strings"
 )
 
+// maxStreamIndexChunks limits the number of chunks the timeline of a stream index may expand to,
+// so a bogus repeat count can't make every manifest request allocate billions of chunks.
+const maxStreamIndexChunks = 1 << 20
+
 type SmoothStream struct {
 	XMLName                xml.Name                 `xml:"SmoothStreamingMedia"`
 	MajorVersion           int                      `xml:"MajorVersion,attr"`
@@ -34,7 +39,7 @@ type StreamIndex struct {
 	Language      string         `xml:"Language,attr"`
 	Subtype       string         `xml:"Subtype,attr"`
 	Chunks        int            `xml:"Chunks,attr"`
-	TimeScale     int            `xml:"TimeScale,attr"`
+	TimeScale     uint64         `xml:"TimeScale,attr"`
 	Url           string         `xml:"Url,attr"`
 	QualityLevels []QualityLevel `xml:"QualityLevel"`
 	ChunkInfos    []ChunkInfos   `xml:"c"`
@@ -57,8 +62,18 @@ type QualityLevel struct {
 
 type ChunkInfos struct {
 	XMLName   xml.Name `xml:"c"`
+	Number    *uint64  `xml:"n,attr"`
 	Duration  uint64   `xml:"d,attr"`
 	StartTime uint64   `xml:"t,attr"`
+	Repeat    uint64   `xml:"r,attr"`
+}
+
+// Chunk represents a single fragment of a stream index with its resolved start time.
+type Chunk struct {
+	// Time is the start time of the fragment in the time scale of the stream index, see StreamIndex.GetTimeScale
+	Time uint64
+	// Duration is the duration of the fragment in the time scale of the stream index, see StreamIndex.GetTimeScale
+	Duration uint64
 }
 
 // SmoothStreamError represents an error in the smoothstreaming manifest parsing process.
@@ -91,6 +106,12 @@ func NewSmoothStream(r io.Reader) (*SmoothStream, error) {
 		ss.TimeScale = 10000000
 	}
 
+	for i := range ss.StreamIndexes {
+		if err := ss.StreamIndexes[i].validateChunks(); err != nil {
+			return nil, err
+		}
+	}
+
 	return &ss, nil
 }
 
@@ -113,6 +134,24 @@ func (ss *SmoothStream) GetStreamIndexByNameOrType(name string) (*StreamIndex, e
 	return nil, NewSmoothStreamError("no stream index found with the specified name")
 }
 
+// GetNameOrType returns the name of the stream index, or its type if it has no name.
+// Stream indexes are identified this way in init and segment URLs.
+func (si *StreamIndex) GetNameOrType() string {
+	if si.Name != "" {
+		return si.Name
+	}
+	return si.Type
+}
+
+// GetTimeScale returns the time scale of the chunk times and durations of the stream index.
+// Stream indexes may define their own time scale, otherwise the one of the manifest applies.
+func (si *StreamIndex) GetTimeScale(manifestTimeScale uint64) uint64 {
+	if si.TimeScale != 0 {
+		return si.TimeScale
+	}
+	return manifestTimeScale
+}
+
 // GetMimeType retrieves the MIME type for a given stream index.
 //
 // If not found, it returns "application/octet-stream" as the default MIME type.
@@ -156,3 +195,56 @@ func (ss *SmoothStream) GetProtectionHeaderForSystemId(systemId string) *SmoothP
 	}
 	return nil
 }
+
+// validateChunks checks that GetChunks can resolve the timeline of the stream index.
+//
+// Chunk numbers (the n attribute) are checked against the index derived from the preceding chunks and repeat counts.
+// They must not go backwards, and a chunk skipping numbers must define its start time, as the start times of
+// the chunks after a gap can't be derived. The timeline must not expand to more than maxStreamIndexChunks chunks.
+func (si *StreamIndex) validateChunks() error {
+	var count, nextNumber uint64
+	var numbered bool
+	for _, info := range si.ChunkInfos {
+		if info.Number != nil {
+			number := *info.Number
+			if numbered && number < nextNumber {
+				return NewSmoothStreamError(fmt.Sprintf("chunk %d of stream index %s follows chunk %d", number, si.GetNameOrType(), nextNumber-1))
+			}
+			if numbered && number > nextNumber && info.StartTime == 0 {
+				return NewSmoothStreamError(fmt.Sprintf("chunks %d to %d of stream index %s are missing and chunk %d has no start time", nextNumber, number-1, si.GetNameOrType(), number))
+			}
+			nextNumber, numbered = number, true
+		}
+
+		repeat := max(info.Repeat, 1)
+		if repeat > maxStreamIndexChunks-count {
+			return NewSmoothStreamError(fmt.Sprintf("stream index %s has more than %d chunks", si.GetNameOrType(), maxStreamIndexChunks))
+		}
+		count += repeat
+		nextNumber += repeat
+	}
+	return nil
+}
+
+// GetChunks resolves the chunk timeline of the stream index.
+// Smooth manifests usually only define the start time of the first chunk, the start time
+// of every other chunk has to be derived from the start time and duration of the previous one.
+// If a chunk defines its own start time, it takes precedence over the derived value.
+// A chunk with a repeat count stands for that many consecutive chunks of the same duration.
+// Stream indexes of manifests parsed by NewSmoothStream are validated, see validateChunks.
+func (si *StreamIndex) GetChunks() []Chunk {
+	chunks := make([]Chunk, 0, len(si.ChunkInfos))
+	var nextTime uint64
+	for i, info := range si.ChunkInfos {
+		startTime := nextTime
+		if i == 0 || info.StartTime != 0 {
+			startTime = info.StartTime
+		}
+		for range max(info.Repeat, 1) {
+			chunks = append(chunks, Chunk{Time: startTime, Duration: info.Duration})
+			startTime += info.Duration
+		}
+		nextTime = startTime
+	}
+	return chunks
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewSmoothStreamValidatesChunks(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks string
+		valid  bool
+	}{
+		{"repeated chunks", `<c n="0" t="0" d="20" r="4" /><c n="4" d="10" /><c n="5" d="20" r="2" />`, true},
+		{"live window", `<c n="1200" t="24000" d="20" /><c d="20" /><c n="1202" d="20" />`, true},
+		{"gap with start time", `<c n="0" t="0" d="20" /><c n="3" t="60" d="20" />`, true},
+		{"gap without start time", `<c n="0" t="0" d="20" /><c n="3" d="20" />`, false},
+		{"reordered", `<c n="0" t="0" d="20" r="2" /><c n="1" d="20" />`, false},
+		{"huge repeat count", `<c t="0" d="20" r="4294967295" />`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := `<SmoothStreamingMedia><StreamIndex Type="video" Name="video">` + tt.chunks + `</StreamIndex></SmoothStreamingMedia>`
+			_, err := NewSmoothStream(strings.NewReader(manifest))
+			if tt.valid && err != nil {
+				t.Errorf("Expected the manifest to be valid, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("Expected the manifest to be rejected")
+			}
+		})
+	}
+}
@@ -3,9 +3,27 @@ package models
 import (
 	"encoding/xml"
 	"fmt"
+	"math/bits"
 	"strconv"
 )
 
+// ScaleTime converts a time (or duration) from one time scale to another, rounding down.
+// The intermediate product is computed with 128 bits, as absolute times of live streams
+// multiplied by a time scale easily overflow 64 bits.
+func ScaleTime(time, fromTimeScale, toTimeScale uint64) uint64 {
+	if fromTimeScale == toTimeScale || fromTimeScale == 0 {
+		return time
+	}
+
+	hi, lo := bits.Mul64(time, toTimeScale)
+	if hi >= fromTimeScale {
+		// the result doesn't fit in 64 bits
+		return ^uint64(0)
+	}
+	quotient, _ := bits.Div64(hi, lo, fromTimeScale)
+	return quotient
+}
+
 // ConditionalUint is a custom type that can be either a uint64 or a bool.
 // It implements the xml.MarshalerAttr and xml.UnmarshalerAttr interfaces
 // to allow for conditional XML attributes in the form of either a number or a boolean.
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+	"github.com/Diniboy1123/manifesto/models"
+	"github.com/Diniboy1123/manifesto/segment"
+	"github.com/Diniboy1123/manifesto/segment/audio"
+	"github.com/Diniboy1123/manifesto/segment/video"
+	"github.com/Diniboy1123/manifesto/transformers"
+	"github.com/Eyevinn/mp4ff/avc"
+	"github.com/Eyevinn/mp4ff/hevc"
+)
+
+// avcProfileNames maps AVC profile_idc values to their names
+var avcProfileNames = map[uint32]string{
+	66:  "Baseline",
+	77:  "Main",
+	88:  "Extended",
+	100: "High",
+	110: "High 10",
+	122: "High 4:2:2",
+	244: "High 4:4:4",
+}
+
+// hevcProfileNames maps HEVC general_profile_idc values to their names
+var hevcProfileNames = map[byte]string{
+	1: "Main",
+	2: "Main 10",
+	3: "Main Still Picture",
+	4: "Range Extensions",
+}
+
+// aacObjectTypeNames maps AAC audio object types to their names
+var aacObjectTypeNames = map[byte]string{
+	1:  "AAC Main",
+	2:  "AAC-LC",
+	5:  "HE-AAC",
+	29: "HE-AACv2",
+}
+
+// runProbe implements the probe subcommand, which prints everything manifesto sees in a Smooth manifest
+// and tries to generate the init segment of every track, like InitHandler would.
+// No config file is needed. It fails if any init segment can't be generated, so unsupported channels
+// can be triaged before they are added to the config.
+func runProbe(args []string) error {
+	flags := flag.NewFlagSet("probe", flag.ExitOnError)
+	keys := flags.String("keys", "", "Comma separated keys in keyId:key format, to check decryption like a channel with keys would")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: manifesto probe [-keys keyId:key,...] <manifest file or URL>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("exactly one manifest is required")
+	}
+
+	channel := config.Channel{Url: flags.Arg(0)}
+	if *keys != "" {
+		channel.Keys = strings.Split(*keys, ",")
+		if _, err := channel.GetKeys(); err != nil {
+			return fmt.Errorf("invalid keys: %v", err)
+		}
+	}
+
+	source, err := openSource(channel.Url)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	ismManifest, err := models.NewSmoothStream(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse manifest: %v", err)
+	}
+
+	return printProbeReport(os.Stdout, ismManifest, channel)
+}
+
+// printProbeReport writes the report of the manifest to w.
+// It returns an error if the init segment of any track can't be generated.
+func printProbeReport(w io.Writer, ismManifest *models.SmoothStream, channel config.Channel) error {
+	fmt.Fprintf(w, "Manifest: %s\n", channel.Url)
+	fmt.Fprintf(w, "Version: %d.%d, time scale %d\n", ismManifest.MajorVersion, ismManifest.MinorVersion, ismManifest.TimeScale)
+	if ismManifest.IsLive {
+		fmt.Fprintf(w, "Type: live, DVR window %s, look ahead %d fragments\n", formatTicks(uint64(ismManifest.DVRWindowLength), ismManifest.TimeScale), ismManifest.LookAheadFragmentCount)
+	} else {
+		fmt.Fprintf(w, "Type: VOD, duration %s\n", formatTicks(ismManifest.Duration, ismManifest.TimeScale))
+	}
+
+	printProtection(w, ismManifest, channel)
+
+	var failed, total int
+	for i := range ismManifest.StreamIndexes {
+		streamIndex := &ismManifest.StreamIndexes[i]
+
+		timeScale := streamIndex.GetTimeScale(ismManifest.TimeScale)
+
+		fmt.Fprintf(w, "\nStream index %s: type %s", streamIndex.GetNameOrType(), streamIndex.Type)
+		if streamIndex.Subtype != "" {
+			fmt.Fprintf(w, ", subtype %s", streamIndex.Subtype)
+		}
+		if streamIndex.Language != "" {
+			fmt.Fprintf(w, ", language %s", streamIndex.Language)
+		}
+		chunks := streamIndex.GetChunks()
+		fmt.Fprintf(w, ", %d chunks", len(chunks))
+		if len(chunks) > 0 {
+			fmt.Fprintf(w, " from %s to %s", formatTicks(chunks[0].Time, timeScale), formatTicks(chunks[len(chunks)-1].Time, timeScale))
+		}
+		fmt.Fprintln(w)
+
+		for j := range streamIndex.QualityLevels {
+			qualityLevel := &streamIndex.QualityLevels[j]
+			total++
+
+			fmt.Fprintf(w, "  %s: FourCC %s, %d kbps\n", transformers.GetRepresentationId(streamIndex, qualityLevel), qualityLevel.FourCC, qualityLevel.Bitrate/1000)
+			for _, line := range describeCodec(streamIndex, qualityLevel) {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+
+			if err := probeInitSegment(ismManifest, streamIndex, qualityLevel, channel); err != nil {
+				failed++
+				fmt.Fprintf(w, "    init segment: FAILED: %v\n", err)
+			} else {
+				fmt.Fprintln(w, "    init segment: ok")
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("init segment generation failed for %d of %d tracks", failed, total)
+	}
+	return nil
+}
+
+// printProtection writes the DRM systems of the manifest with the key IDs and license server URL of PlayReady.
+func printProtection(w io.Writer, ismManifest *models.SmoothStream, channel config.Channel) {
+	if len(ismManifest.Protection) == 0 {
+		fmt.Fprintln(w, "DRM: none")
+		return
+	}
+
+	for _, protection := range ismManifest.Protection {
+		fmt.Fprintf(w, "DRM: system ID %s\n", strings.ToLower(protection.SystemID))
+	}
+
+	keyIds, pssh, err := utils.ExtractPRKeyIds(ismManifest.Protection)
+	if err != nil {
+		fmt.Fprintf(w, "  PlayReady header: %v\n", err)
+		return
+	}
+	if pssh == nil {
+		return
+	}
+
+	for _, keyId := range keyIds {
+		keyState := "no key"
+		if key, err := channel.GetKey(keyId); err == nil && len(key) > 0 {
+			keyState = "key given"
+		}
+		fmt.Fprintf(w, "  PlayReady KID: %s (%s)\n", formatKeyId(keyId), keyState)
+	}
+	if len(keyIds) > 1 {
+		fmt.Fprintln(w, "  Multiple KIDs: the server picks the KID of each track from its first chunk")
+	}
+
+	laUrl, err := utils.ExtractPRLaUrlFromPssh(pssh)
+	switch {
+	case err != nil:
+		fmt.Fprintf(w, "  PlayReady LA_URL: %v\n", err)
+	case laUrl != "":
+		fmt.Fprintf(w, "  PlayReady LA_URL: %s\n", laUrl)
+	}
+}
+
+// describeCodec returns human readable details parsed from the CodecPrivateData of the quality level.
+// Parsing errors are returned as details as well, as they are exactly what the probe is looking for.
+func describeCodec(streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel) []string {
+	switch streamIndex.Type {
+	case "video":
+		sampleEntry, err := video.SampleEntryForFourCC(qualityLevel.FourCC)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		if sampleEntry == "avc1" {
+			return describeAvc(qualityLevel)
+		}
+		return describeHevc(qualityLevel, sampleEntry)
+	case "audio":
+		switch strings.ToLower(qualityLevel.FourCC) {
+		case "aacl":
+			asc, err := audio.CodecPrivateDataToAudioSpecificConfig(qualityLevel.CodecPrivateData)
+			if err != nil {
+				return []string{fmt.Sprintf("AudioSpecificConfig: %v", err)}
+			}
+			objectType := aacObjectTypeNames[asc.ObjectType]
+			if objectType == "" {
+				objectType = fmt.Sprintf("object type %d", asc.ObjectType)
+			}
+			return []string{fmt.Sprintf("AudioSpecificConfig: %s, %d Hz, channel configuration %d, SBR %t, PS %t",
+				objectType, asc.SamplingFrequency, asc.ChannelConfiguration, asc.SBRPresentFlag, asc.PSPresentFlag)}
+		case "ec-3":
+			dec3, err := audio.CodecPrivateDataToDec3Box(qualityLevel.CodecPrivateData)
+			if err != nil {
+				return []string{fmt.Sprintf("dec3: %v", err)}
+			}
+			nrChannels, _ := dec3.ChannelInfo()
+			details := []string{fmt.Sprintf("dec3: %d kbps, %d channels, %d independent substreams", dec3.DataRate, nrChannels, len(dec3.EC3Subs))}
+			for i, sub := range dec3.EC3Subs {
+				details = append(details, fmt.Sprintf("  substream %d: fscod %d, bsid %d, bsmod %d, acmod %d, lfeon %d, dependent substreams %d",
+					i, sub.FSCod, sub.BSID, sub.BSMod, sub.ACMod, sub.LFEOn, sub.NumDepSub))
+			}
+			return details
+		}
+		return []string{fmt.Sprintf("%d Hz, %d channels", qualityLevel.SamplingRate, qualityLevel.Channels)}
+	}
+	return nil
+}
+
+// describeAvc returns the details of the AVC SPS of the quality level.
+func describeAvc(qualityLevel *models.QualityLevel) []string {
+	spsNALUs, _, err := video.CodecPrivateDataToSPSPPS(qualityLevel.CodecPrivateData)
+	if err != nil {
+		return []string{fmt.Sprintf("SPS: %v", err)}
+	}
+	sps, err := avc.ParseSPSNALUnit(spsNALUs[0], true)
+	if err != nil {
+		return []string{fmt.Sprintf("SPS: %v", err)}
+	}
+
+	profile := avcProfileNames[sps.Profile]
+	if profile == "" {
+		profile = fmt.Sprintf("profile %d", sps.Profile)
+	}
+
+	var frameRate string
+	if sps.VUI != nil && sps.VUI.TimingInfoPresentFlag && sps.VUI.NumUnitsInTick > 0 {
+		// AVC counts fields, so a frame takes two ticks
+		frameRate = fmt.Sprintf(", %.3f fps", float64(sps.VUI.TimeScale)/float64(2*sps.VUI.NumUnitsInTick))
+	}
+
+	return []string{fmt.Sprintf("SPS: %s, level %.1f, %dx%d%s, codecs %s",
+		profile, float64(sps.Level)/10, sps.Width, sps.Height, frameRate, avc.CodecString("avc1", sps))}
+}
+
+// describeHevc returns the details of the HEVC SPS of the quality level.
+func describeHevc(qualityLevel *models.QualityLevel, sampleEntry string) []string {
+	_, spsNALUs, _, err := video.CodecPrivateDataToVPSSPSPPS(qualityLevel.CodecPrivateData)
+	if err != nil {
+		return []string{fmt.Sprintf("SPS: %v", err)}
+	}
+	sps, err := hevc.ParseSPSNALUnit(spsNALUs[0])
+	if err != nil {
+		return []string{fmt.Sprintf("SPS: %v", err)}
+	}
+
+	profileIdc := sps.ProfileTierLevel.GeneralProfileIDC
+	profile := hevcProfileNames[profileIdc]
+	if profile == "" {
+		profile = fmt.Sprintf("profile %d", profileIdc)
+	}
+
+	var frameRate string
+	if sps.VUI != nil && sps.VUI.TimingInfoPresentFlag && sps.VUI.NumUnitsInTick > 0 {
+		frameRate = fmt.Sprintf(", %.3f fps", float64(sps.VUI.TimeScale)/float64(sps.VUI.NumUnitsInTick))
+	}
+
+	width, height := sps.ImageSize()
+	return []string{fmt.Sprintf("SPS: %s, level %.1f, %dx%d%s, codecs %s",
+		profile, float64(sps.ProfileTierLevel.GeneralLevelIDC)/30, width, height, frameRate, hevc.CodecString(sampleEntry, sps))}
+}
+
+// probeInitSegment generates and encodes the init segment of the quality level the same way InitHandler does,
+// except that the key ID of tracks is taken from the manifest or track_key_ids, as no chunks are fetched.
+// Tracks of channels with keys for several of the listed key IDs therefore fail with a DRM error
+// unless their key ID is configured.
+func probeInitSegment(ismManifest *models.SmoothStream, streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel, channel config.Channel) error {
+	baseSegment := segment.BaseInitSegment{
+		TimeScale:        uint32(streamIndex.GetTimeScale(ismManifest.TimeScale)),
+		Lang:             streamIndex.Language,
+		CodecPrivateData: qualityLevel.CodecPrivateData,
+	}
+
+	if ismManifest.Protection != nil {
+		keyId, key, pssh, err := utils.ExtractKeyInfo(ismManifest.Protection, channel, transformers.GetTrackKeyId(channel, streamIndex, qualityLevel, nil))
+		if err != nil {
+			return fmt.Errorf("DRM error: %v", err)
+		}
+		baseSegment.KeyId = keyId
+		baseSegment.Key = key
+		baseSegment.Pssh = pssh
+	}
+
+	initSegment, _, err := transformers.GenerateInitSegment(streamIndex, qualityLevel, baseSegment)
+	if err != nil {
+		return err
+	}
+	return initSegment.Encode(io.Discard)
+}
+
+// formatTicks formats a time in the given time scale as seconds.
+func formatTicks(ticks, timeScale uint64) string {
+	return fmt.Sprintf("%.3fs", float64(ticks)/float64(timeScale))
+}
+
+// formatKeyId formats a key ID in the usual UUID notation.
+func formatKeyId(keyId []byte) string {
+	if len(keyId) != 16 {
+		return hex.EncodeToString(keyId)
+	}
+	h := hex.EncodeToString(keyId)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/recorder"
+)
+
+// runRecord implements the record subcommand, which records a live channel of the config to the recordings directory
+// in the foreground. The recording can be served by the server afterwards, like ones created via the admin endpoints.
+// Interrupting the command stops the recording, keeping what was recorded so far.
+func runRecord(args []string) error {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "Path to the configuration file")
+	channelPath := flags.String("channel", "", "Channel to record as group/channel")
+	representations := flags.String("representations", "", "Comma separated representation IDs to record (e.g. video_0,audio_deu_0), all of them if empty")
+	start := flags.String("start", "", "Start time in RFC 3339 format (e.g. 2025-01-01T20:00:00Z), now if empty")
+	end := flags.String("end", "", "End time in RFC 3339 format, either -end or -duration is required")
+	duration := flags.Duration("duration", 0, "Duration of the recording, either -end or -duration is required")
+	flags.Parse(args)
+
+	group, channelId, found := strings.Cut(*channelPath, "/")
+	if !found {
+		flags.Usage()
+		return errors.New("-channel must be given as group/channel")
+	}
+
+	var startTime, endTime time.Time
+	var err error
+	if *start != "" {
+		if startTime, err = time.Parse(time.RFC3339, *start); err != nil {
+			return fmt.Errorf("invalid -start: %v", err)
+		}
+	} else {
+		startTime = time.Now()
+	}
+
+	switch {
+	case *end != "" && *duration != 0:
+		return errors.New("only one of -end and -duration can be set")
+	case *end != "":
+		if endTime, err = time.Parse(time.RFC3339, *end); err != nil {
+			return fmt.Errorf("invalid -end: %v", err)
+		}
+	case *duration > 0:
+		endTime = startTime.Add(*duration)
+	default:
+		flags.Usage()
+		return errors.New("either -end or -duration is required")
+	}
+
+	if err := config.LoadConfig(*configPath); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+
+	var representationIds []string
+	if *representations != "" {
+		representationIds = strings.Split(*representations, ",")
+	}
+
+	rec, err := recorder.Create(group, channelId, representationIds, startTime, endTime)
+	if err != nil {
+		return err
+	}
+	log.Printf("Recording %s until %s", rec.Id, rec.End.Local().Format(time.DateTime))
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		if _, ok := <-signals; ok {
+			cancel(recorder.ErrStopped)
+		}
+	}()
+
+	return recorder.Run(ctx, rec.Id)
+}
@@ -0,0 +1,119 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+)
+
+// runningRecording is a recording running in the background of this process.
+type runningRecording struct {
+	cancel context.CancelCauseFunc
+	// done is closed once the recording returned
+	done chan struct{}
+}
+
+var (
+	// runningMu protects running and baseCtx
+	runningMu sync.Mutex
+	// running holds the recordings running in the background by ID
+	running = make(map[string]*runningRecording)
+	// baseCtx is the parent context of background recordings, canceled on shutdown
+	baseCtx = context.Background()
+	// runningWg tracks background recordings, so shutdown can wait for them
+	runningWg sync.WaitGroup
+)
+
+// Resume starts every recording that isn't done yet in the background, which picks up recordings
+// interrupted by a restart. Recordings started later with Start are canceled along with ctx, but keep
+// their state, so they are resumed on the next Resume.
+func Resume(ctx context.Context) {
+	runningMu.Lock()
+	baseCtx = ctx
+	runningMu.Unlock()
+
+	recordings, err := List()
+	if err != nil {
+		log.Printf("Failed to list recordings: %v", err)
+		return
+	}
+
+	for _, rec := range recordings {
+		if !rec.IsDone() {
+			Start(rec.Id)
+		}
+	}
+}
+
+// Start runs the recording with the given ID in the background, see Run.
+// It does nothing if the recording is already running.
+func Start(id string) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+
+	if _, found := running[id]; found {
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(baseCtx)
+	r := &runningRecording{cancel: cancel, done: make(chan struct{})}
+	running[id] = r
+
+	runningWg.Add(1)
+	go func() {
+		defer runningWg.Done()
+		defer func() {
+			runningMu.Lock()
+			delete(running, id)
+			runningMu.Unlock()
+			close(r.done)
+		}()
+
+		if err := Run(ctx, id); err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("Recording %s: %v", id, err)
+		}
+	}()
+}
+
+// Stop stops a recording running in the background and waits until it is saved.
+// What was recorded so far is kept and can still be played.
+//
+// If the recording isn't running in this process, it returns ErrNotRunning.
+func Stop(id string) error {
+	runningMu.Lock()
+	r, found := running[id]
+	runningMu.Unlock()
+
+	if !found {
+		return ErrNotRunning
+	}
+
+	r.cancel(ErrStopped)
+	<-r.done
+	return nil
+}
+
+// Delete removes a recording with all of its files, stopping it first if it is running.
+func Delete(id string) error {
+	if _, err := Get(id); err != nil {
+		return err
+	}
+
+	if err := Stop(id); err != nil && !errors.Is(err, ErrNotRunning) {
+		return err
+	}
+
+	dir, err := recordingDir(id)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// Wait blocks until every background recording returned.
+// Call it after canceling the context passed to Resume to shut down cleanly.
+func Wait() {
+	runningWg.Wait()
+}
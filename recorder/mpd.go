@@ -0,0 +1,92 @@
+package recorder
+
+import (
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/models"
+	"github.com/Diniboy1123/manifesto/transformers"
+)
+
+// writeMpd writes the static MPEG-DASH manifest of everything recorded so far.
+// It is built by the same transformer as live manifests, so recordings use the same URL scheme.
+func (rec *Recording) writeMpd(snapshot *models.SmoothStream, channel config.Channel) error {
+	manifest, timeOffset := buildRecordedManifest(snapshot, rec)
+
+	// init segments of recordings only carry the PlayReady pssh box of the source
+	channel.Widevine = false
+
+	mpd, err := transformers.SmoothToDashManifest(manifest, channel.ShouldDecrypt(), config.Get().AllowSubs, channel, "", "")
+	if err != nil {
+		return err
+	}
+
+	// segments keep the times of the source, so the presentation is shifted to start with the first one
+	for _, period := range mpd.Period {
+		for _, adaptationSet := range period.AdaptationSets {
+			segmentTemplate := adaptationSet.SegmentTemplate
+			segmentTemplate.PresentationTimeOffset = models.ScaleTime(timeOffset, manifest.TimeScale, segmentTemplate.Timescale)
+		}
+	}
+
+	mpdData, err := mpd.Encode()
+	if err != nil {
+		return err
+	}
+
+	mpdPath, err := ManifestPath(rec.Id)
+	if err != nil {
+		return err
+	}
+	return writeFile(mpdPath, mpdData)
+}
+
+// buildRecordedManifest builds a VOD Smooth manifest of the recorded chunks from the snapshot of the source manifest.
+// Only recorded stream indexes and quality levels are kept.
+// It also returns the start time of the earliest recorded chunk in the time scale of the manifest, which is where the presentation starts.
+func buildRecordedManifest(snapshot *models.SmoothStream, rec *Recording) (*models.SmoothStream, uint64) {
+	manifest := *snapshot
+	manifest.IsLive = false
+	manifest.DVRWindowLength = 0
+	manifest.LookAheadFragmentCount = 0
+	manifest.StreamIndexes = nil
+
+	var startTime, endTime uint64
+	for _, streamIndex := range snapshot.StreamIndexes {
+		timeline := rec.Timelines[streamIndex.GetNameOrType()]
+		if len(timeline) == 0 {
+			continue
+		}
+
+		var qualityLevels []models.QualityLevel
+		for _, qualityLevel := range streamIndex.QualityLevels {
+			if _, found := rec.MimeTypes[transformers.GetRepresentationId(&streamIndex, &qualityLevel)]; found {
+				qualityLevels = append(qualityLevels, qualityLevel)
+			}
+		}
+		if len(qualityLevels) == 0 {
+			continue
+		}
+
+		// every chunk gets its start time, so gaps in the recording are kept
+		chunkInfos := make([]models.ChunkInfos, 0, len(timeline))
+		for _, chunk := range timeline {
+			chunkInfos = append(chunkInfos, models.ChunkInfos{StartTime: chunk.Time, Duration: chunk.Duration})
+		}
+
+		// stream indexes may have their own time scales, so times are compared in the one of the manifest
+		timeScale := streamIndex.GetTimeScale(snapshot.TimeScale)
+		firstTime := models.ScaleTime(timeline[0].Time, timeScale, snapshot.TimeScale)
+		if len(manifest.StreamIndexes) == 0 || firstTime < startTime {
+			startTime = firstTime
+		}
+		lastChunk := timeline[len(timeline)-1]
+		endTime = max(endTime, models.ScaleTime(lastChunk.Time+lastChunk.Duration, timeScale, snapshot.TimeScale))
+
+		streamIndex.QualityLevels = qualityLevels
+		streamIndex.ChunkInfos = chunkInfos
+		streamIndex.Chunks = len(chunkInfos)
+		manifest.StreamIndexes = append(manifest.StreamIndexes, streamIndex)
+	}
+
+	manifest.Duration = endTime - startTime
+	return &manifest, startTime
+}
@@ -0,0 +1,434 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+	"github.com/Diniboy1123/manifesto/models"
+	"github.com/Diniboy1123/manifesto/segment"
+	"github.com/Diniboy1123/manifesto/transformers"
+)
+
+// pollInterval is how often the source manifest is checked for new chunks while recording
+const pollInterval = 2 * time.Second
+
+// ErrStopped is the cancellation cause of recordings stopped on purpose, see Run
+var ErrStopped = errors.New("recording stopped")
+
+// Create validates a recording of the given channel and saves it in the scheduled state, without starting it.
+// The source manifest is fetched to check that the channel is live and the requested representations exist.
+// If no representations are given, every representation of the channel is recorded.
+// If start is zero, the recording starts right away.
+//
+// Invalid parameters are reported with errors wrapping ErrInvalid.
+func Create(group, channelId string, representations []string, start, end time.Time) (*Recording, error) {
+	recordingsDir := config.Get().RecordingsDir
+	if recordingsDir == "" {
+		return nil, ErrDisabled
+	}
+
+	channel, found := config.Get().GetChannel(group, channelId)
+	if !found {
+		return nil, fmt.Errorf("%w: channel %s/%s not found", ErrInvalid, group, channelId)
+	}
+
+	if start.IsZero() {
+		start = time.Now()
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("%w: end must be after start", ErrInvalid)
+	}
+	if !end.After(time.Now()) {
+		return nil, fmt.Errorf("%w: end must be in the future", ErrInvalid)
+	}
+
+	_, smoothStream, err := fetchManifest(channel.Url)
+	if err != nil {
+		return nil, err
+	}
+	if !smoothStream.IsLive {
+		return nil, fmt.Errorf("%w: channel %s/%s is not live", ErrInvalid, group, channelId)
+	}
+
+	rec := &Recording{
+		Id:        fmt.Sprintf("%s-%s-%s", group, channelId, start.UTC().Format("20060102T150405")),
+		Group:     group,
+		ChannelId: channelId,
+		MimeTypes: make(map[string]string),
+		Start:     start.UTC(),
+		End:       end.UTC(),
+		State:     StateScheduled,
+		Timelines: make(map[string][]Chunk),
+	}
+	if !validName.MatchString(rec.Id) {
+		return nil, fmt.Errorf("%w: channel %s/%s can't be used in file names", ErrInvalid, group, channelId)
+	}
+
+	for i := range smoothStream.StreamIndexes {
+		streamIndex := &smoothStream.StreamIndexes[i]
+		switch streamIndex.Type {
+		case "video", "audio", "text":
+		default:
+			continue
+		}
+
+		for j := range streamIndex.QualityLevels {
+			representationId := transformers.GetRepresentationId(streamIndex, &streamIndex.QualityLevels[j])
+			if len(representations) > 0 && !slices.Contains(representations, representationId) {
+				continue
+			}
+			rec.Representations = append(rec.Representations, representationId)
+			rec.MimeTypes[representationId] = streamIndex.GetMimeType()
+		}
+	}
+	for _, representationId := range representations {
+		if _, found := rec.MimeTypes[representationId]; !found {
+			return nil, fmt.Errorf("%w: representation %s not found", ErrInvalid, representationId)
+		}
+	}
+	if len(rec.Representations) == 0 {
+		return nil, fmt.Errorf("%w: no representations to record", ErrInvalid)
+	}
+
+	if err := os.MkdirAll(recordingsDir, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := recordingDir(rec.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(dir, 0755); errors.Is(err, os.ErrExist) {
+		return nil, ErrExists
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := rec.save(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Run records the recording with the given ID and blocks until it is done.
+// It waits for the start time of the recording first, and resumes where it left off if it was
+// interrupted before, as long as the missing chunks are still in the DVR window of the source.
+//
+// If ctx is canceled with ErrStopped as its cause, the recording is stopped and keeps what was recorded so far.
+// Other cancellations leave the state as is, so the recording is resumed on the next Run.
+func Run(ctx context.Context, id string) error {
+	rec, err := Get(id)
+	if err != nil {
+		return err
+	}
+	if rec.IsDone() {
+		return fmt.Errorf("recording %s is already %s", id, rec.State)
+	}
+	if rec.Timelines == nil {
+		rec.Timelines = make(map[string][]Chunk)
+	}
+
+	if wait := time.Until(rec.Start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return rec.interrupt(ctx)
+		case <-timer.C:
+		}
+	}
+
+	if rec.State != StateRecording {
+		rec.State = StateRecording
+		if err := rec.save(); err != nil {
+			return err
+		}
+	}
+	log.Printf("Recording %s started", rec.Id)
+
+	for {
+		if err := rec.poll(); err != nil {
+			return rec.finish(StateFailed, err)
+		}
+		if !time.Now().Before(rec.End) {
+			return rec.finish(StateFinished, nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return rec.interrupt(ctx)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// interrupt handles the cancellation of a running recording, see Run.
+func (rec *Recording) interrupt(ctx context.Context) error {
+	if errors.Is(context.Cause(ctx), ErrStopped) {
+		return rec.finish(StateStopped, nil)
+	}
+	return ctx.Err()
+}
+
+// finish puts the recording in a final state and saves it.
+// It returns cause, so failures can be returned directly.
+func (rec *Recording) finish(state string, cause error) error {
+	rec.State = state
+	if cause != nil {
+		rec.Error = cause.Error()
+	}
+	if err := rec.save(); err != nil {
+		return err
+	}
+
+	log.Printf("Recording %s %s", rec.Id, state)
+	return cause
+}
+
+// poll records the chunks added to the source manifest since the last poll and updates the MPEG-DASH manifest.
+// Chunks that can't be recorded are logged and retried on the next poll, only errors that prevent
+// the recording from continuing are returned.
+func (rec *Recording) poll() error {
+	channel, found := config.Get().GetChannel(rec.Group, rec.ChannelId)
+	if !found {
+		return fmt.Errorf("channel %s/%s no longer exists", rec.Group, rec.ChannelId)
+	}
+
+	manifestData, liveStream, err := fetchManifest(channel.Url)
+	if err != nil {
+		log.Printf("Recording %s: %v", rec.Id, err)
+		return nil
+	}
+
+	snapshot, err := rec.loadSnapshot(manifestData)
+	if err != nil {
+		return err
+	}
+
+	var recorded bool
+	for i := range snapshot.StreamIndexes {
+		streamIndex := &snapshot.StreamIndexes[i]
+
+		qualityLevels := rec.selectQualityLevels(streamIndex)
+		if len(qualityLevels) == 0 {
+			continue
+		}
+
+		name := streamIndex.GetNameOrType()
+		liveStreamIndex, err := liveStream.GetStreamIndexByNameOrType(name)
+		if err != nil {
+			log.Printf("Recording %s: stream index %s is missing from the source manifest", rec.Id, name)
+			continue
+		}
+
+		for _, chunk := range newChunks(liveStreamIndex.GetChunks(), rec.Timelines[name]) {
+			if err := rec.recordChunk(channel, snapshot, streamIndex, qualityLevels, chunk); err != nil {
+				log.Printf("Recording %s: %v", rec.Id, err)
+				break
+			}
+			rec.Timelines[name] = append(rec.Timelines[name], Chunk{Time: chunk.Time, Duration: chunk.Duration})
+			recorded = true
+		}
+	}
+
+	if !recorded {
+		return nil
+	}
+	if err := rec.save(); err != nil {
+		return err
+	}
+	return rec.writeMpd(snapshot, channel)
+}
+
+// loadSnapshot returns the source manifest the recording was started with.
+// Codec and protection data of every recorded segment is taken from it, so the segments match the
+// init segments written at the beginning. If the snapshot doesn't exist yet, manifestData is saved as the snapshot.
+func (rec *Recording) loadSnapshot(manifestData []byte) (*models.SmoothStream, error) {
+	dir, err := recordingDir(rec.Id)
+	if err != nil {
+		return nil, err
+	}
+	snapshotPath := filepath.Join(dir, snapshotFileName)
+
+	snapshotData, err := os.ReadFile(snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := writeFile(snapshotPath, manifestData); err != nil {
+			return nil, err
+		}
+		snapshotData = manifestData
+	} else if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := models.NewSmoothStream(bytes.NewReader(snapshotData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest snapshot: %v", err)
+	}
+	return snapshot, nil
+}
+
+// selectQualityLevels returns the quality levels of the stream index that are part of the recording.
+func (rec *Recording) selectQualityLevels(streamIndex *models.StreamIndex) []*models.QualityLevel {
+	var qualityLevels []*models.QualityLevel
+	for i := range streamIndex.QualityLevels {
+		qualityLevel := &streamIndex.QualityLevels[i]
+		if slices.Contains(rec.Representations, transformers.GetRepresentationId(streamIndex, qualityLevel)) {
+			qualityLevels = append(qualityLevels, qualityLevel)
+		}
+	}
+	return qualityLevels
+}
+
+// recordChunk fetches the given chunk of the source manifest for every quality level, repackages them and
+// writes the segments to disk. The init segment of a quality level is written along with its first segment.
+func (rec *Recording) recordChunk(channel config.Channel, snapshot *models.SmoothStream, streamIndex *models.StreamIndex, qualityLevels []*models.QualityLevel, chunk models.Chunk) error {
+	time := chunk.Time
+	for _, qualityLevel := range qualityLevels {
+		representationId := transformers.GetRepresentationId(streamIndex, qualityLevel)
+
+		chunkData, err := fetch(transformers.GetChunkUrl(channel.Url, transformers.GetChunkPath(streamIndex, qualityLevel, time)))
+		if err != nil {
+			return fmt.Errorf("failed to fetch chunk %d of %s: %v", time, representationId, err)
+		}
+
+		initData, segmentData, err := repackageChunk(channel, snapshot, streamIndex, qualityLevel, chunkData, chunk)
+		if err != nil {
+			return fmt.Errorf("failed to repackage chunk %d of %s: %v", time, representationId, err)
+		}
+
+		initPath, err := InitPath(rec.Id, representationId)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(initPath), 0755); err != nil {
+			return err
+		}
+		if _, err := os.Stat(initPath); errors.Is(err, os.ErrNotExist) {
+			if err := writeFile(initPath, initData); err != nil {
+				return err
+			}
+		}
+
+		segmentPath, err := SegmentPath(rec.Id, representationId, time)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(segmentPath, segmentData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repackageChunk generates the init segment of the quality level and repackages the chunk the same way
+// the segment handler does, decrypting it if the channel is decrypted server-side.
+// The timeline of the snapshot ends where the recording started, so the duration is taken from the chunk of the source manifest.
+func repackageChunk(channel config.Channel, snapshot *models.SmoothStream, streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel, chunkData []byte, chunk models.Chunk) ([]byte, []byte, error) {
+	baseSegment := segment.BaseInitSegment{
+		TimeScale:        uint32(streamIndex.GetTimeScale(snapshot.TimeScale)),
+		Lang:             streamIndex.Language,
+		CodecPrivateData: qualityLevel.CodecPrivateData,
+	}
+
+	var key []byte
+	if snapshot.Protection != nil {
+		keyId, trackKey, pssh, err := utils.ExtractKeyInfo(snapshot.Protection, channel, transformers.GetTrackKeyId(channel, streamIndex, qualityLevel, chunkData))
+		if err != nil {
+			return nil, nil, fmt.Errorf("DRM error: %v", err)
+		}
+
+		// in ClearKey mode, players decrypt the segments themselves
+		if channel.ShouldDecrypt() {
+			key = trackKey
+		}
+
+		baseSegment.KeyId = keyId
+		baseSegment.Key = key
+		baseSegment.Pssh = pssh
+	}
+
+	initSegment, decryptInfo, err := transformers.GenerateInitSegment(streamIndex, qualityLevel, baseSegment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	initData := bytes.NewBuffer(nil)
+	if err := initSegment.Encode(initData); err != nil {
+		return nil, nil, err
+	}
+
+	segmentData, err := transformers.ProcessSegment(streamIndex, uint64(baseSegment.TimeScale), chunkData, segment.ProcessOptions{
+		DecryptInfo: decryptInfo,
+		Key:         key,
+		Time:        chunk.Time,
+		Duration:    chunk.Duration,
+		RewriteTfdt: channel.RewriteTfdt,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return initData.Bytes(), segmentData, nil
+}
+
+// newChunks returns the chunks that start after the last recorded chunk.
+// If nothing was recorded yet, only the latest chunk is returned, so recordings start at the live edge
+// instead of the beginning of the DVR window.
+func newChunks(chunks []models.Chunk, recorded []Chunk) []models.Chunk {
+	if len(chunks) == 0 {
+		return nil
+	}
+	if len(recorded) == 0 {
+		return chunks[len(chunks)-1:]
+	}
+
+	lastTime := recorded[len(recorded)-1].Time
+	for i, chunk := range chunks {
+		if chunk.Time > lastTime {
+			return chunks[i:]
+		}
+	}
+	return nil
+}
+
+// fetchManifest fetches and parses the source manifest, bypassing the cache so every poll sees the latest chunks.
+// The raw manifest is returned as well, so it can be saved as the snapshot of a recording.
+func fetchManifest(url string) ([]byte, *models.SmoothStream, error) {
+	data, err := fetch(url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch manifest: %v", err)
+	}
+
+	smoothStream, err := models.NewSmoothStream(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	return data, smoothStream, nil
+}
+
+// fetch downloads the given URL without caching it, as chunks are only recorded once
+// and manifests must be up to date.
+//
+// If the upstream server doesn't respond with 200 OK, it returns a utils.StatusError.
+func fetch(url string) ([]byte, error) {
+	resp, err := utils.DoUncachedRequest(http.MethodGet, url, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &utils.StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,162 @@
+package recorder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/models"
+)
+
+func TestNewChunks(t *testing.T) {
+	chunks := []models.Chunk{
+		{Time: 100, Duration: 10},
+		{Time: 110, Duration: 10},
+		{Time: 120, Duration: 10},
+	}
+
+	// recordings start at the live edge
+	if got := newChunks(chunks, nil); len(got) != 1 || got[0].Time != 120 {
+		t.Fatalf("Expected only the latest chunk, got %+v", got)
+	}
+
+	if got := newChunks(chunks, []Chunk{{Time: 100, Duration: 10}}); len(got) != 2 || got[0].Time != 110 {
+		t.Fatalf("Expected the chunks after 100, got %+v", got)
+	}
+
+	if got := newChunks(chunks, []Chunk{{Time: 120, Duration: 10}}); len(got) != 0 {
+		t.Fatalf("Expected no new chunks, got %+v", got)
+	}
+
+	// chunks that dropped out of the DVR window while the recording was interrupted are skipped
+	if got := newChunks(chunks, []Chunk{{Time: 50, Duration: 10}}); len(got) != 3 {
+		t.Fatalf("Expected every chunk, got %+v", got)
+	}
+}
+
+func TestBuildRecordedManifest(t *testing.T) {
+	snapshot := &models.SmoothStream{
+		TimeScale:       10000000,
+		IsLive:          true,
+		DVRWindowLength: 300000000,
+		StreamIndexes: []models.StreamIndex{
+			{
+				Type:          "video",
+				Name:          "video",
+				QualityLevels: []models.QualityLevel{{Index: 0}, {Index: 1}},
+				ChunkInfos:    []models.ChunkInfos{{StartTime: 1000, Duration: 20}},
+			},
+			{
+				Type:          "audio",
+				Name:          "audio_deu",
+				QualityLevels: []models.QualityLevel{{Index: 0}},
+			},
+			{
+				Type:          "text",
+				Name:          "textstream_deu",
+				QualityLevels: []models.QualityLevel{{Index: 0}},
+			},
+		},
+	}
+	rec := &Recording{
+		MimeTypes: map[string]string{"video_1": "video/mp4", "audio_deu_0": "audio/mp4"},
+		Timelines: map[string][]Chunk{
+			"video":     {{Time: 200, Duration: 20}, {Time: 240, Duration: 20}},
+			"audio_deu": {{Time: 190, Duration: 20}, {Time: 210, Duration: 20}},
+		},
+	}
+
+	manifest, timeOffset := buildRecordedManifest(snapshot, rec)
+
+	if manifest.IsLive || manifest.DVRWindowLength != 0 {
+		t.Fatal("Expected a VOD manifest")
+	}
+	if timeOffset != 190 {
+		t.Fatalf("Expected the presentation to start at 190, got %d", timeOffset)
+	}
+	if manifest.Duration != 70 {
+		t.Fatalf("Expected a duration of 70, got %d", manifest.Duration)
+	}
+	if len(manifest.StreamIndexes) != 2 {
+		t.Fatalf("Expected only the recorded stream indexes, got %d", len(manifest.StreamIndexes))
+	}
+
+	video := manifest.StreamIndexes[0]
+	if len(video.QualityLevels) != 1 || video.QualityLevels[0].Index != 1 {
+		t.Fatalf("Expected only the recorded quality level, got %+v", video.QualityLevels)
+	}
+
+	// the gap between 220 and 240 must be kept
+	chunks := video.GetChunks()
+	if len(chunks) != 2 || chunks[0].Time != 200 || chunks[1].Time != 240 {
+		t.Fatalf("Unexpected chunks %+v", chunks)
+	}
+
+	if len(snapshot.StreamIndexes[0].QualityLevels) != 2 || len(snapshot.StreamIndexes[0].ChunkInfos) != 1 {
+		t.Fatal("Expected the snapshot to be left untouched")
+	}
+}
+
+func TestBuildRecordedManifestTimeScales(t *testing.T) {
+	snapshot := &models.SmoothStream{
+		TimeScale: 10000000,
+		IsLive:    true,
+		StreamIndexes: []models.StreamIndex{
+			{Type: "video", Name: "video", TimeScale: 90000, QualityLevels: []models.QualityLevel{{Index: 0}}},
+			{Type: "audio", Name: "audio", TimeScale: 48000, QualityLevels: []models.QualityLevel{{Index: 0}}},
+		},
+	}
+	rec := &Recording{
+		MimeTypes: map[string]string{"video_0": "video/mp4", "audio_0": "audio/mp4"},
+		Timelines: map[string][]Chunk{
+			// video starts at 10s and ends at 14s, audio starts at 9s and ends at 13s
+			"video": {{Time: 900000, Duration: 180000}, {Time: 1080000, Duration: 180000}},
+			"audio": {{Time: 432000, Duration: 96000}, {Time: 528000, Duration: 96000}},
+		},
+	}
+
+	manifest, timeOffset := buildRecordedManifest(snapshot, rec)
+
+	if timeOffset != 90000000 {
+		t.Fatalf("Expected the presentation to start at 9s in the time scale of the manifest, got %d", timeOffset)
+	}
+	if manifest.Duration != 50000000 {
+		t.Fatalf("Expected a duration of 5s, got %d", manifest.Duration)
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	schedule := config.RecordingSchedule{
+		Cron:     "30 20 * * 6",
+		Duration: config.JSONDuration(2 * time.Hour),
+		TimeZone: "UTC",
+	}
+
+	// Saturday 2025-01-04 before the recording
+	start, end, found := nextWindow(schedule, time.Date(2025, 1, 4, 12, 0, 0, 0, time.UTC))
+	if !found || !start.Equal(time.Date(2025, 1, 4, 20, 30, 0, 0, time.UTC)) || !end.Equal(start.Add(2*time.Hour)) {
+		t.Fatalf("Unexpected window %s - %s", start, end)
+	}
+
+	// the recording in progress is returned until it ends
+	start, _, _ = nextWindow(schedule, time.Date(2025, 1, 4, 22, 0, 0, 0, time.UTC))
+	if !start.Equal(time.Date(2025, 1, 4, 20, 30, 0, 0, time.UTC)) {
+		t.Fatalf("Expected the recording in progress, got %s", start)
+	}
+
+	start, _, _ = nextWindow(schedule, time.Date(2025, 1, 4, 22, 30, 0, 0, time.UTC))
+	if !start.Equal(time.Date(2025, 1, 11, 20, 30, 0, 0, time.UTC)) {
+		t.Fatalf("Expected the next week's recording, got %s", start)
+	}
+
+	oneOff := config.RecordingSchedule{
+		Start:    time.Date(2025, 1, 4, 20, 0, 0, 0, time.UTC),
+		Duration: config.JSONDuration(time.Hour),
+	}
+	if _, end, found := nextWindow(oneOff, time.Date(2025, 1, 4, 20, 30, 0, 0, time.UTC)); !found || !end.Equal(oneOff.Start.Add(time.Hour)) {
+		t.Fatalf("Expected the one-off recording to be in progress, got %s", end)
+	}
+	if _, _, found := nextWindow(oneOff, time.Date(2025, 1, 4, 21, 0, 0, 0, time.UTC)); found {
+		t.Fatal("Expected no window after the one-off recording ended")
+	}
+}
@@ -0,0 +1,227 @@
+// Package recorder records live Smooth channels to disk as CMAF segments with a static MPEG-DASH manifest.
+// Recordings are kept in the configured recordings directory, one directory per recording, together with
+// their state, so they can be served and resumed after a restart.
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+)
+
+// Recording states
+const (
+	// StateScheduled is the state of recordings waiting for their start time
+	StateScheduled = "scheduled"
+	// StateRecording is the state of recordings in progress
+	StateRecording = "recording"
+	// StateFinished is the state of recordings that reached their end time
+	StateFinished = "finished"
+	// StateStopped is the state of recordings stopped before their end time
+	StateStopped = "stopped"
+	// StateFailed is the state of recordings that couldn't be continued
+	StateFailed = "failed"
+)
+
+// Names of the files inside a recording directory
+const (
+	stateFileName    = "recording.json"
+	snapshotFileName = "Manifest.ismc"
+	mpdFileName      = "manifest.mpd"
+	initFileName     = "init.mp4"
+)
+
+var (
+	// ErrDisabled is returned if no recordings directory is configured
+	ErrDisabled = errors.New("recording is disabled")
+	// ErrNotFound is returned if a recording doesn't exist
+	ErrNotFound = errors.New("recording not found")
+	// ErrExists is returned when creating a recording that already exists
+	ErrExists = errors.New("recording already exists")
+	// ErrInvalid is wrapped by errors about invalid recording parameters
+	ErrInvalid = errors.New("invalid recording")
+	// ErrNotRunning is returned when stopping a recording that isn't running in this process
+	ErrNotRunning = errors.New("recording is not running")
+)
+
+// validName matches IDs that are safe to use as file names
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Recording is the persisted state of a recording.
+type Recording struct {
+	// Id is the unique identifier of the recording, used in URLs and as its directory name
+	Id string `json:"id"`
+	// Group is the group name of the recorded channel
+	Group string `json:"group"`
+	// ChannelId is the ID of the recorded channel
+	ChannelId string `json:"channel_id"`
+	// Representations holds the IDs of the recorded quality levels (e.g. "video_0")
+	Representations []string `json:"representations"`
+	// MimeTypes maps the recorded representation IDs to the MIME type of their segments
+	MimeTypes map[string]string `json:"mime_types"`
+	// Start is the time the recording starts at
+	Start time.Time `json:"start"`
+	// End is the time the recording ends at
+	End time.Time `json:"end"`
+	// State is one of the State constants
+	State string `json:"state"`
+	// Error holds the reason a recording failed
+	Error string `json:"error,omitempty"`
+	// Timelines holds the recorded chunks by stream index name in the time scale of their stream index
+	Timelines map[string][]Chunk `json:"timelines,omitempty"`
+}
+
+// Chunk is a recorded chunk of a stream index.
+type Chunk struct {
+	// Time is the start time of the chunk
+	Time uint64 `json:"t"`
+	// Duration is the duration of the chunk
+	Duration uint64 `json:"d"`
+}
+
+// IsDone reports whether the recording reached a final state and won't be continued.
+func (rec *Recording) IsDone() bool {
+	return rec.State == StateFinished || rec.State == StateStopped || rec.State == StateFailed
+}
+
+// Get loads the recording with the given ID.
+//
+// If the recording doesn't exist, it returns ErrNotFound.
+func Get(id string) (*Recording, error) {
+	dir, err := recordingDir(id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, stateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to decode recording %s: %v", id, err)
+	}
+	return &rec, nil
+}
+
+// List loads every recording in the recordings directory, sorted by start time.
+// Directories without a readable state are skipped.
+func List() ([]Recording, error) {
+	recordingsDir := config.Get().RecordingsDir
+	if recordingsDir == "" {
+		return nil, ErrDisabled
+	}
+
+	entries, err := os.ReadDir(recordingsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Recording{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := []Recording{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		rec, err := Get(entry.Name())
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, *rec)
+	}
+
+	slices.SortFunc(recordings, func(a, b Recording) int {
+		return a.Start.Compare(b.Start)
+	})
+	return recordings, nil
+}
+
+// ManifestPath returns the path of the MPEG-DASH manifest of a recording.
+func ManifestPath(id string) (string, error) {
+	dir, err := recordingDir(id)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, mpdFileName), nil
+}
+
+// InitPath returns the path of the init segment of a recorded representation.
+func InitPath(id, representationId string) (string, error) {
+	dir, err := representationDir(id, representationId)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, initFileName), nil
+}
+
+// SegmentPath returns the path of the segment of a recorded representation starting at the given time.
+func SegmentPath(id, representationId string, time uint64) (string, error) {
+	dir, err := representationDir(id, representationId)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.FormatUint(time, 10)+".m4s"), nil
+}
+
+// recordingDir returns the directory of the recording with the given ID.
+// IDs are validated, so they can't point outside the recordings directory.
+func recordingDir(id string) (string, error) {
+	recordingsDir := config.Get().RecordingsDir
+	if recordingsDir == "" {
+		return "", ErrDisabled
+	}
+	if !validName.MatchString(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(recordingsDir, id), nil
+}
+
+// representationDir returns the directory holding the segments of a recorded representation.
+func representationDir(id, representationId string) (string, error) {
+	dir, err := recordingDir(id)
+	if err != nil {
+		return "", err
+	}
+	if !validName.MatchString(representationId) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(dir, representationId), nil
+}
+
+// save writes the state of the recording to its directory.
+func (rec *Recording) save() error {
+	dir, err := recordingDir(rec.Id)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, stateFileName), data)
+}
+
+// writeFile writes data to a temporary file first and renames it afterwards,
+// so readers never see partially written files.
+func writeFile(path string, data []byte) error {
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
@@ -0,0 +1,126 @@
+package recorder
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/cron"
+)
+
+const (
+	// maxSchedulerWait is the longest the scheduler sleeps without looking at the schedule again
+	maxSchedulerWait = time.Hour
+	// schedulerRetryInterval is how long the scheduler waits before retrying a recording that couldn't be created
+	schedulerRetryInterval = 30 * time.Second
+)
+
+// RunScheduler starts the recordings scheduled in the config once they are due, until ctx is canceled.
+// Recordings are created with their scheduled start time, so a recording that is already running or was
+// recorded before isn't created twice, even across restarts. The schedule is reevaluated whenever the config is reloaded.
+//
+// Recordings started by the scheduler run in the background like the ones started with Start, so Resume must be called first.
+func RunScheduler(ctx context.Context) {
+	reloaded := config.NotifyReload()
+
+	// started holds the end times of occurrences handled by this scheduler by occurrence key,
+	// so recordings deleted while they are due aren't recreated
+	started := make(map[string]time.Time)
+
+	for {
+		timer := time.NewTimer(startDueRecordings(time.Now(), started))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-reloaded:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// startDueRecordings creates and starts every scheduled recording whose window includes now.
+// It returns how long to wait until the next scheduled recording is due.
+func startDueRecordings(now time.Time, started map[string]time.Time) time.Duration {
+	for key, end := range started {
+		if !end.After(now) {
+			delete(started, key)
+		}
+	}
+
+	wait := maxSchedulerWait
+	for _, schedule := range config.Get().Recordings {
+		start, end, found := nextWindow(schedule, now)
+		if !found {
+			continue
+		}
+		if start.After(now) {
+			wait = min(wait, start.Sub(now))
+			continue
+		}
+
+		key := schedule.Channel + "@" + start.Format(time.RFC3339)
+		if _, found := started[key]; found {
+			continue
+		}
+
+		group, channelId, _ := strings.Cut(schedule.Channel, "/")
+		rec, err := Create(group, channelId, schedule.Representations, start, end)
+		switch {
+		case errors.Is(err, ErrExists):
+			// already recording, Resume takes care of it after restarts
+			started[key] = end
+			continue
+		case errors.Is(err, ErrInvalid):
+			log.Printf("Scheduled recording of %s: %v", schedule.Channel, err)
+			started[key] = end
+			continue
+		case err != nil:
+			log.Printf("Scheduled recording of %s: %v, retrying in %s", schedule.Channel, err, schedulerRetryInterval)
+			wait = min(wait, schedulerRetryInterval)
+			continue
+		}
+
+		started[key] = end
+		Start(rec.Id)
+	}
+
+	return wait
+}
+
+// nextWindow returns the start and end time of the occurrence of a scheduled recording that is in progress at now,
+// or the next one if none is. It returns false if the schedule has no occurrences left.
+func nextWindow(schedule config.RecordingSchedule, now time.Time) (time.Time, time.Time, bool) {
+	if schedule.Cron == "" {
+		end := schedule.End
+		if end.IsZero() {
+			end = schedule.Start.Add(schedule.Duration.Duration())
+		}
+		return schedule.Start, end, end.After(now)
+	}
+
+	cronSchedule, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	location := time.Local
+	if schedule.TimeZone != "" {
+		if location, err = time.LoadLocation(schedule.TimeZone); err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+	}
+
+	// the first occurrence after now - duration is either in progress or the next one
+	duration := schedule.Duration.Duration()
+	start := cronSchedule.Next(now.Add(-duration).In(location))
+	if start.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, start.Add(duration), true
+}
@@ -49,7 +49,7 @@ func (s *AACInitSegment) Generate() (*mp4.InitSegment, mp4.DecryptInfo, error) {
 	}
 
 	if s.KeyId != nil && s.Pssh != nil {
-		decryptInfo, err := segment.AddPrEncryption(init, s.Key, s.KeyId, s.Pssh)
+		decryptInfo, err := segment.AddPrEncryption(init, s.Key, s.KeyId, s.Pssh, s.ExtraPsshBoxes)
 		return init, decryptInfo, err
 	}
 
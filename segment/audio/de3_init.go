@@ -78,7 +78,7 @@ func (s *De3InitSegment) Generate() (*mp4.InitSegment, mp4.DecryptInfo, error) {
 	}
 
 	if s.KeyId != nil && s.Pssh != nil {
-		decryptInfo, err := segment.AddPrEncryption(init, s.Key, s.KeyId, s.Pssh)
+		decryptInfo, err := segment.AddPrEncryption(init, s.Key, s.KeyId, s.Pssh, s.ExtraPsshBoxes)
 		return init, decryptInfo, err
 	}
 
@@ -20,24 +20,30 @@ type BaseInitSegment struct {
 	Key []byte
 	// Pssh is the PSSH data for encryption.
 	Pssh []byte
+	// ExtraPsshBoxes are added to the init segment after the PlayReady pssh box (e.g. Widevine or W3C common PSSH).
+	ExtraPsshBoxes []*mp4.PsshBox
 }
 
 // AddPrEncryption adds encryption information to the initialization segment.
 // It initializes the protection system and returns the decryption information.
+// The extra PSSH boxes are added after the PlayReady one.
 // If the key is nil, it returns an empty DecryptInfo.
 //
 // If an error occurs during the process, it returns the error.
-func AddPrEncryption(init *mp4.InitSegment, key, keyId, pssh []byte) (mp4.DecryptInfo, error) {
+func AddPrEncryption(init *mp4.InitSegment, key, keyId, pssh []byte, extraPsshBoxes []*mp4.PsshBox) (mp4.DecryptInfo, error) {
 	uuid, err := mp4.NewUUIDFromString(mp4.UUIDPlayReady)
 	if err != nil {
 		return mp4.DecryptInfo{}, fmt.Errorf("failed to parse UUID: %v", err)
 	}
-	psshBox := mp4.PsshBox{
-		SystemID: uuid,
-		Data:     pssh,
+	psshBoxes := []*mp4.PsshBox{
+		{
+			SystemID: uuid,
+			Data:     pssh,
+		},
 	}
+	psshBoxes = append(psshBoxes, extraPsshBoxes...)
 
-	_, err = mp4.InitProtect(init, key, nil, "cenc", keyId, []*mp4.PsshBox{&psshBox})
+	_, err = mp4.InitProtect(init, key, nil, "cenc", keyId, psshBoxes)
 	if err != nil {
 		return mp4.DecryptInfo{}, fmt.Errorf("failed to initialize protection: %v", err)
 	}
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/Diniboy1123/manifesto/segment"
 	"github.com/Eyevinn/mp4ff/mp4"
 )
 
@@ -21,15 +22,18 @@ const (
 
 // ProcessSubtitleSegment processes a subtitle segment, overrides the track ID,
 // and adds a tfdt box if missing. It takes an input buffer containing the segment
-// data and a chunk ID. It returns an output buffer containing the processed
+// data, the time scale of the stream and the processing options holding the time
+// and duration of the chunk. It returns an output buffer containing the processed
 // segment data and any error encountered during processing.
 // The function handles fragmented MP4 files and ensures that the output is
 // properly formatted for playback. It also handles the case where the input
 // MP4 file is not fragmented, returning an error in that case.
 //
 // Note: Subtitle decryption is not supported in this implementation.
-func ProcessSubtitleSegment(input *bytes.Buffer, chunkId uint64, timeScale uint32, segmentDuration uint32) ([]byte, error) {
+func ProcessSubtitleSegment(input *bytes.Buffer, timeScale uint32, options segment.ProcessOptions) ([]byte, error) {
 	output := bytes.NewBuffer(nil)
+	chunkId := options.Time
+	segmentDuration := uint32(options.Duration)
 
 	inMp4, err := mp4.DecodeFile(input)
 	if err != nil {
@@ -54,7 +58,7 @@ func ProcessSubtitleSegment(input *bytes.Buffer, chunkId uint64, timeScale uint3
 
 			// VLC has delayed audio when tfdt is missing
 			// kinda hacky, because time isn't always equal to chunkId, but it works
-			if !hasTfdt {
+			if !hasTfdt && !options.SkipTfdt {
 				fragment.Moof.Traf.AddChild(mp4.CreateTfdt(chunkId))
 			}
 
@@ -67,9 +71,8 @@ func ProcessSubtitleSegment(input *bytes.Buffer, chunkId uint64, timeScale uint3
 			}
 
 			// Apparently the sidx box is required for ffmpeg to process subtitle streams without errors.
-			// The timescale and duration values used here are not ideal, but if the remote end
-			// does not provide these values, we rely on the values defined in the manifest.
-			if !hasSidx && timeScale > 0 && segmentDuration > 0 {
+			// If the remote end doesn't provide one, we rely on the time scale and chunk duration defined in the manifest.
+			if !hasSidx && !options.SkipSidx && timeScale > 0 && segmentDuration > 0 {
 				// Ensure the sidx box is added as the first child to avoid playback issues in some players.
 				fragment.Children = append([]mp4.Box{
 					&mp4.SidxBox{
@@ -77,8 +80,8 @@ func ProcessSubtitleSegment(input *bytes.Buffer, chunkId uint64, timeScale uint3
 						// ReferenceID corresponds to the hardcoded TrackID.
 						ReferenceID: 1,
 						Timescale:   timeScale,
-						// EarliestPresentationTime is set to a value I observed in working samples.
-						EarliestPresentationTime: 17443164950004000,
+						// The segment starts at the chunk's time, which is in the same time scale.
+						EarliestPresentationTime: chunkId,
 						FirstOffset:              0,
 						SidxRefs: []mp4.SidxRef{
 							{
@@ -109,6 +112,12 @@ func ProcessSubtitleSegment(input *bytes.Buffer, chunkId uint64, timeScale uint3
 
 			fragment.Mdat.SetData([]byte(enhancedTTML))
 
+			// The sample size must still match the enhanced TTML, which can't be split up among several samples,
+			// so those fragments get the rewritten sample definitions below.
+			if options.KeepSubtitleTrun && setSingleSampleSize(fragment.Moof.Traf, uint32(len(enhancedTTML))) {
+				continue
+			}
+
 			// When modifying the MDAT box, it is necessary to update the sample size. Most segments I encountered had
 			// pre-populated sample definitions in the trun boxes, but certain players did not handle this well.
 			// Setting the tfhd default sample size and default sample duration is generally better respected by players.
@@ -144,6 +153,31 @@ func ProcessSubtitleSegment(input *bytes.Buffer, chunkId uint64, timeScale uint3
 	return output.Bytes(), nil
 }
 
+// setSingleSampleSize sets the size of the only sample of the track fragment, either in its trun box
+// or as the default sample size of the tfhd box if the trun box doesn't list sample sizes.
+// It returns false without changing anything if the track fragment doesn't have exactly one sample.
+func setSingleSampleSize(traf *mp4.TrafBox, size uint32) bool {
+	var sampleTrun *mp4.TrunBox
+	var sampleCount uint32
+	for _, trun := range traf.Truns {
+		sampleCount += trun.SampleCount()
+		if trun.SampleCount() > 0 {
+			sampleTrun = trun
+		}
+	}
+	if sampleCount != 1 {
+		return false
+	}
+
+	if sampleTrun.HasSampleSize() {
+		sampleTrun.Samples[0].Size = size
+	} else {
+		traf.Tfhd.DefaultSampleSize = size
+		traf.Tfhd.Flags |= DefaultSampleSizePresent
+	}
+	return true
+}
+
 // UpdateTTMLToAbsoluteTimestamps updates relative TTML timestamps to absolute ones for smooth streaming manifests.
 // It parses the TTML XML, adjusts the 'begin' and 'end' attributes of <p> elements by adding the segment's start time in seconds,
 // and returns the modified TTML as a string. Returns an error if XML parsing fails.
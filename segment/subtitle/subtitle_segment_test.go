@@ -0,0 +1,37 @@
+package subtitle
+
+import (
+	"testing"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+func TestSetSingleSampleSize(t *testing.T) {
+	newTraf := func(trunFlags uint32, samples ...mp4.Sample) *mp4.TrafBox {
+		trun := mp4.CreateTrun(0)
+		trun.Flags = trunFlags
+		for _, sample := range samples {
+			trun.AddSample(sample)
+		}
+		return &mp4.TrafBox{Tfhd: mp4.CreateTfhd(1), Truns: []*mp4.TrunBox{trun}}
+	}
+
+	traf := newTraf(mp4.TrunSampleSizePresentFlag, mp4.Sample{Size: 10})
+	if !setSingleSampleSize(traf, 42) || traf.Truns[0].Samples[0].Size != 42 {
+		t.Errorf("Expected the trun sample size to be 42, got %d", traf.Truns[0].Samples[0].Size)
+	}
+
+	// without sample sizes in the trun box, the default sample size of the tfhd box applies
+	traf = newTraf(0, mp4.Sample{})
+	if !setSingleSampleSize(traf, 42) || traf.Tfhd.DefaultSampleSize != 42 || !traf.Tfhd.HasDefaultSampleSize() {
+		t.Errorf("Expected a default sample size of 42, got %d", traf.Tfhd.DefaultSampleSize)
+	}
+
+	traf = newTraf(mp4.TrunSampleSizePresentFlag, mp4.Sample{Size: 10}, mp4.Sample{Size: 20})
+	if setSingleSampleSize(traf, 42) {
+		t.Errorf("Expected several samples to be rejected")
+	}
+	if traf.Truns[0].Samples[0].Size != 10 || traf.Truns[0].Samples[1].Size != 20 {
+		t.Errorf("Expected the sample sizes to be unchanged")
+	}
+}
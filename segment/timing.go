@@ -0,0 +1,113 @@
+package segment
+
+import (
+	"log"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// ProcessOptions holds the parameters of repackaging a media segment.
+type ProcessOptions struct {
+	// DecryptInfo is the decryption information returned when generating the init segment.
+	DecryptInfo mp4.DecryptInfo
+	// Key is the decryption key. The segment is left encrypted if nil.
+	Key []byte
+	// Time is the start time of the chunk in the manifest timeline. Init segments use the time scale
+	// of the stream index, so it is in the time scale of the track as well.
+	Time uint64
+	// Duration is the duration of the chunk in the manifest timeline, 0 if unknown.
+	Duration uint64
+	// RewriteTfdt sets the decode time of every fragment from the manifest timeline, even if the chunk has a tfdt box.
+	// The sample durations are checked against Duration too.
+	RewriteTfdt bool
+	// SkipTfdt doesn't add tfdt boxes to fragments without one. Ignored if RewriteTfdt is set.
+	SkipTfdt bool
+	// KeepSdtp keeps the sdtp boxes of video fragments.
+	KeepSdtp bool
+	// SkipSidx doesn't add sidx boxes to subtitle fragments without one.
+	SkipSidx bool
+	// KeepSubtitleTrun keeps the trun samples of subtitle fragments instead of replacing them with default values in tfhd.
+	KeepSubtitleTrun bool
+}
+
+// SetDecodeTimes sets the tfdt boxes of the fragments of a media segment.
+//
+// By default, a tfdt box with the time of the chunk is only added to fragments without one, unless SkipTfdt is set.
+// If RewriteTfdt is set, every fragment gets the decode time derived from the time of the chunk and the
+// durations of the preceding fragments. If the samples don't add up to the duration of the chunk,
+// the duration of the last sample is corrected if possible, so the next chunk doesn't overlap or leave a gap.
+func SetDecodeTimes(seg *mp4.MediaSegment, options ProcessOptions) {
+	if !options.RewriteTfdt {
+		if options.SkipTfdt {
+			return
+		}
+		for _, fragment := range seg.Fragments {
+			// VLC has delayed audio when tfdt is missing
+			// kinda hacky, because time isn't always equal to chunkId, but it works
+			if fragment.Moof.Traf.Tfdt == nil {
+				fragment.Moof.Traf.AddChild(mp4.CreateTfdt(options.Time))
+			}
+		}
+		return
+	}
+
+	decodeTime := options.Time
+	for _, fragment := range seg.Fragments {
+		traf := fragment.Moof.Traf
+		if traf.Tfdt == nil {
+			traf.AddChild(mp4.CreateTfdt(decodeTime))
+		} else {
+			traf.Tfdt.SetBaseMediaDecodeTime(decodeTime)
+		}
+
+		for _, trun := range traf.Truns {
+			decodeTime += trun.Duration(traf.Tfhd.DefaultSampleDuration)
+		}
+	}
+
+	// the sample durations may only be known from the trex box of the init segment, which we don't check
+	if options.Duration == 0 || decodeTime == options.Time {
+		return
+	}
+
+	drift := int64(options.Duration) - int64(decodeTime-options.Time)
+	if drift == 0 {
+		return
+	}
+	if !correctLastSampleDuration(seg.Fragments[len(seg.Fragments)-1].Moof.Traf, drift) {
+		log.Printf("Chunk at %d has samples of %d in total, but a duration of %d in the manifest", options.Time, decodeTime-options.Time, options.Duration)
+	}
+}
+
+// correctLastSampleDuration adds drift to the duration of the last sample of the track fragment.
+// Sample durations are written to the trun box if it relied on the default sample duration.
+//
+// It returns false if the drift is too large to be corrected this way, which is left as is.
+func correctLastSampleDuration(traf *mp4.TrafBox, drift int64) bool {
+	if len(traf.Truns) == 0 {
+		return false
+	}
+	trun := traf.Truns[len(traf.Truns)-1]
+	if trun.SampleCount() == 0 {
+		return false
+	}
+
+	lastDuration := int64(traf.Tfhd.DefaultSampleDuration)
+	if trun.HasSampleDuration() {
+		lastDuration = int64(trun.Samples[len(trun.Samples)-1].Dur)
+	}
+
+	// a larger drift means the manifest or the chunk is off, not the rounding of sample durations
+	if drift <= -lastDuration || drift >= lastDuration {
+		return false
+	}
+
+	if !trun.HasSampleDuration() {
+		for i := range trun.Samples {
+			trun.Samples[i].Dur = traf.Tfhd.DefaultSampleDuration
+		}
+		trun.Flags |= mp4.TrunSampleDurationPresentFlag
+	}
+	trun.Samples[len(trun.Samples)-1].Dur = uint32(lastDuration + drift)
+	return true
+}
@@ -0,0 +1,56 @@
+package segment
+
+import (
+	"testing"
+
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// newTestSegment creates a media segment with a single fragment of three samples of 1000 starting at decodeTime.
+func newTestSegment(t *testing.T, decodeTime uint64) *mp4.MediaSegment {
+	fragment, err := mp4.CreateFragment(1, 1)
+	if err != nil {
+		t.Fatalf("Failed to create fragment: %v", err)
+	}
+	for i := range 3 {
+		fragment.AddFullSample(mp4.FullSample{
+			Sample:     mp4.Sample{Dur: 1000, Size: 1},
+			DecodeTime: decodeTime + uint64(i)*1000,
+			Data:       []byte{0},
+		})
+	}
+
+	seg := mp4.NewMediaSegment()
+	seg.AddFragment(fragment)
+	return seg
+}
+
+func TestSetDecodeTimes(t *testing.T) {
+	// existing tfdt boxes are kept by default
+	seg := newTestSegment(t, 42)
+	SetDecodeTimes(seg, ProcessOptions{Time: 5000, Duration: 3000})
+	if decodeTime := seg.Fragments[0].Moof.Traf.Tfdt.BaseMediaDecodeTime(); decodeTime != 42 {
+		t.Fatalf("Expected the tfdt of the source to be kept, got %d", decodeTime)
+	}
+
+	seg = newTestSegment(t, 42)
+	SetDecodeTimes(seg, ProcessOptions{Time: 5000, Duration: 3000, RewriteTfdt: true})
+	if decodeTime := seg.Fragments[0].Moof.Traf.Tfdt.BaseMediaDecodeTime(); decodeTime != 5000 {
+		t.Fatalf("Expected the tfdt to be rewritten, got %d", decodeTime)
+	}
+
+	// small differences are corrected with the last sample
+	seg = newTestSegment(t, 0)
+	SetDecodeTimes(seg, ProcessOptions{Time: 5000, Duration: 3010, RewriteTfdt: true})
+	samples := seg.Fragments[0].Moof.Traf.Trun.Samples
+	if samples[1].Dur != 1000 || samples[2].Dur != 1010 {
+		t.Fatalf("Expected the last sample to be stretched, got %+v", samples)
+	}
+
+	// large differences are left as is
+	seg = newTestSegment(t, 0)
+	SetDecodeTimes(seg, ProcessOptions{Time: 5000, Duration: 9000, RewriteTfdt: true})
+	if samples := seg.Fragments[0].Moof.Traf.Trun.Samples; samples[2].Dur != 1000 {
+		t.Fatalf("Expected the samples to be left as is, got %+v", samples)
+	}
+}
@@ -55,7 +55,7 @@ func (s *AVCInitSegment) Generate() (*mp4.InitSegment, mp4.DecryptInfo, error) {
 	}
 
 	if s.KeyId != nil && s.Pssh != nil {
-		decryptionInfo, err := segment.AddPrEncryption(init, s.Key, s.KeyId, s.Pssh)
+		decryptionInfo, err := segment.AddPrEncryption(init, s.Key, s.KeyId, s.Pssh, s.ExtraPsshBoxes)
 		return init, decryptionInfo, err
 	}
 
@@ -0,0 +1,24 @@
+package video
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SampleEntryForFourCC maps the FourCC of a Smooth quality level to the sample entry type
+// used in the generated init segment and codec strings.
+// Empty FourCCs are treated as H.264, since that is what older manifests implied.
+//
+// If the FourCC belongs to an unsupported codec, it returns an error.
+func SampleEntryForFourCC(fourCC string) (string, error) {
+	switch strings.ToLower(fourCC) {
+	case "", "h264", "avc1", "avcb", "davc":
+		return "avc1", nil
+	case "h265", "hevc", "hvc1":
+		return "hvc1", nil
+	case "hev1":
+		return "hev1", nil
+	default:
+		return "", fmt.Errorf("unsupported video FourCC %q", fourCC)
+	}
+}
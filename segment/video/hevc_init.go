@@ -0,0 +1,87 @@
+package video
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Diniboy1123/manifesto/segment"
+	"github.com/Eyevinn/mp4ff/hevc"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// HEVCInitSegment represents an initialization segment for HEVC video streams.
+type HEVCInitSegment struct {
+	segment.BaseInitSegment
+	// SampleEntry is the sample entry type to use, either "hvc1" or "hev1".
+	// Defaults to "hvc1" if left empty.
+	SampleEntry string
+}
+
+// CodecPrivateDataToVPSSPSPPS converts codec private data in hex format to VPS, SPS and PPS NALUs.
+// It decodes the hex string, splits it at the start codes and sorts the NALUs by their type.
+// Both 3 and 4 byte start codes are supported.
+//
+// If any of the parameter sets is missing, it returns an error.
+func CodecPrivateDataToVPSSPSPPS(codecPrivateDataHex string) (vpsNALUs, spsNALUs, ppsNALUs [][]byte, err error) {
+	codecPrivateData, err := hex.DecodeString(codecPrivateDataHex)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to decode codecPrivateDataHex: %v", err)
+	}
+
+	for _, nalu := range bytes.Split(codecPrivateData, []byte{0, 0, 1}) {
+		// the leading zero of a 4 byte start code ends up at the end of the previous NALU
+		nalu = bytes.TrimRight(nalu, "\x00")
+		if len(nalu) < 2 {
+			continue
+		}
+
+		switch hevc.GetNaluType(nalu[0]) {
+		case hevc.NALU_VPS:
+			vpsNALUs = append(vpsNALUs, nalu)
+		case hevc.NALU_SPS:
+			spsNALUs = append(spsNALUs, nalu)
+		case hevc.NALU_PPS:
+			ppsNALUs = append(ppsNALUs, nalu)
+		}
+	}
+
+	if len(vpsNALUs) == 0 || len(spsNALUs) == 0 || len(ppsNALUs) == 0 {
+		return nil, nil, nil, fmt.Errorf("invalid codecPrivateDataHex format, VPS, SPS and PPS are required")
+	}
+
+	return vpsNALUs, spsNALUs, ppsNALUs, nil
+}
+
+// Generate creates an initialization segment for HEVC video streams.
+// It sets the video configuration based on the provided codec private data and
+// adds encryption information if a key ID and PSSH data are provided.
+// It returns the generated initialization segment and any decryption information.
+//
+// If an error occurs during the generation process, it returns the error.
+//
+// The function also sets the language and time scale for the segment.
+func (s *HEVCInitSegment) Generate() (*mp4.InitSegment, mp4.DecryptInfo, error) {
+	vpsNALUs, spsNALUs, ppsNALUs, err := CodecPrivateDataToVPSSPSPPS(s.CodecPrivateData)
+	if err != nil {
+		return nil, mp4.DecryptInfo{}, err
+	}
+
+	sampleEntry := s.SampleEntry
+	if sampleEntry == "" {
+		sampleEntry = "hvc1"
+	}
+
+	init := segment.NewBaseInitSegment("video", s.Lang, s.TimeScale, []string{"iso6", "piff", sampleEntry})
+	err = init.Moov.Trak.SetHEVCDescriptor(sampleEntry, vpsNALUs, spsNALUs, ppsNALUs, nil, true)
+	if err != nil {
+		return nil, mp4.DecryptInfo{}, err
+	}
+
+	if s.KeyId != nil && s.Pssh != nil {
+		decryptionInfo, err := segment.AddPrEncryption(init, s.Key, s.KeyId, s.Pssh, s.ExtraPsshBoxes)
+		return init, decryptionInfo, err
+	}
+
+	return init, mp4.DecryptInfo{}, nil
+}
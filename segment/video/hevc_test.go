@@ -0,0 +1,56 @@
+package video
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestCodecPrivateDataToVPSSPSPPS(t *testing.T) {
+	expectedVPS := "40010c01ffff016000000300900000030000030078959809"
+	expectedSPS := "420101016000000300900000030000030078a00502016965959a4932bc05a80808082000000300200000030321"
+	expectedPPS := "4401c172b46240"
+
+	tests := []string{
+		"00000001" + expectedVPS + "00000001" + expectedSPS + "00000001" + expectedPPS,
+		"000001" + expectedVPS + "000001" + expectedSPS + "00000001" + expectedPPS,
+	}
+
+	for _, codecPrivateData := range tests {
+		vpsNALUs, spsNALUs, ppsNALUs, err := CodecPrivateDataToVPSSPSPPS(codecPrivateData)
+		if err != nil {
+			t.Fatalf("Failed to convert codecPrivateData to VPS/SPS/PPS: %v", err)
+		}
+
+		if len(vpsNALUs) != 1 || len(spsNALUs) != 1 || len(ppsNALUs) != 1 {
+			t.Fatalf("Expected exactly one VPS, SPS and PPS NALU, got %d, %d and %d", len(vpsNALUs), len(spsNALUs), len(ppsNALUs))
+		}
+		if hex.EncodeToString(vpsNALUs[0]) != expectedVPS {
+			t.Fatalf("Expected VPS NALU %s, got %s", expectedVPS, hex.EncodeToString(vpsNALUs[0]))
+		}
+		if hex.EncodeToString(spsNALUs[0]) != expectedSPS {
+			t.Fatalf("Expected SPS NALU %s, got %s", expectedSPS, hex.EncodeToString(spsNALUs[0]))
+		}
+		if hex.EncodeToString(ppsNALUs[0]) != expectedPPS {
+			t.Fatalf("Expected PPS NALU %s, got %s", expectedPPS, hex.EncodeToString(ppsNALUs[0]))
+		}
+	}
+}
+
+func TestHEVCInitSegmentGenerate(t *testing.T) {
+	hevcInitSegment := HEVCInitSegment{}
+	hevcInitSegment.TimeScale = 10000000
+	hevcInitSegment.Lang = "und"
+	hevcInitSegment.CodecPrivateData = "0000000140010c01ffff016000000300900000030000030078959809" +
+		"00000001420101016000000300900000030000030078a00502016965959a4932bc05a80808082000000300200000030321" +
+		"000000014401c172b46240"
+
+	init, _, err := hevcInitSegment.Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate init segment: %v", err)
+	}
+
+	sampleEntry := init.Moov.Trak.Mdia.Minf.Stbl.Stsd.Children[0]
+	if sampleEntry.Type() != "hvc1" {
+		t.Fatalf("Expected hvc1 sample entry, got %s", sampleEntry.Type())
+	}
+}
@@ -4,21 +4,23 @@ import (
 	"bytes"
 	"fmt"
 
+	"github.com/Diniboy1123/manifesto/segment"
 	"github.com/Eyevinn/mp4ff/mp4"
 )
 
 // ProcessVideoSegment processes a video segment, overrides the track ID,
-// and adds a tfdt box if missing. It takes an input buffer containing the segment if a key is provided.
-// It takes an input buffer containing the segment data, decrypt information,
-// a key for decryption, and a chunk ID. It returns an output buffer containing
+// and adds a tfdt box if missing. It also decrypts the segment if a key is provided.
+// It takes an input buffer containing the segment data and the processing options
+// (see segment.ProcessOptions). It returns an output buffer containing
 // the processed segment data and any error encountered during processing.
 // The function handles fragmented MP4 files and ensures that the output is
 // properly formatted for playback. It also handles the case where the input
 // MP4 file is not fragmented, returning an error in that case.
 //
-// The function also removes the sdtp box if present, as it is not needed for MPEG-DASH.
+// The function also removes the sdtp box if present (unless KeepSdtp is set), as it is not needed for MPEG-DASH.
 // The tfdt box is added if it is missing, as some players require it for proper track synchronization.
-func ProcessVideoSegment(input *bytes.Buffer, decryptInfo mp4.DecryptInfo, key []byte, chunkId uint64) ([]byte, error) {
+// If RewriteTfdt is set in the options, existing tfdt boxes are rewritten from the manifest timeline as well.
+func ProcessVideoSegment(input *bytes.Buffer, options segment.ProcessOptions) ([]byte, error) {
 	output := bytes.NewBuffer(nil)
 
 	inMp4, err := mp4.DecodeFile(input)
@@ -43,25 +45,20 @@ func ProcessVideoSegment(input *bytes.Buffer, decryptInfo mp4.DecryptInfo, key [
 			// according to ISO_IEC_14496-12_2015 its useful for seeking
 			// https://wiki.gpac.io/MP4Box/mp4box-dash-opts/#options this states that its smooth like
 			// stream works fine without it
-			var hasTfdt bool
+			if options.KeepSdtp {
+				continue
+			}
 			for i, child := range fragment.Moof.Traf.Children {
 				if child.Type() == "sdtp" {
 					fragment.Moof.Traf.Children = append(fragment.Moof.Traf.Children[:i], fragment.Moof.Traf.Children[i+1:]...)
 				}
-				if child.Type() == "tfdt" {
-					hasTfdt = true
-				}
-			}
-
-			// VLC has delayed audio when tfdt is missing
-			// kinda hacky, because time isn't always equal to chunkId, but it works
-			if !hasTfdt {
-				fragment.Moof.Traf.AddChild(mp4.CreateTfdt(chunkId))
 			}
 		}
 
-		if key != nil {
-			err = mp4.DecryptSegment(seg, decryptInfo, key)
+		segment.SetDecodeTimes(seg, options)
+
+		if options.Key != nil {
+			err = mp4.DecryptSegment(seg, options.DecryptInfo, options.Key)
 			if err != nil {
 				return nil, fmt.Errorf("failed to decrypt segment: %v", err)
 			}
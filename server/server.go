@@ -8,26 +8,83 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Diniboy1123/manifesto/config"
 	"github.com/Diniboy1123/manifesto/handlers"
 	"github.com/Diniboy1123/manifesto/middleware"
+	"github.com/Diniboy1123/manifesto/recorder"
 )
 
 // buildChain constructs a middleware chain for the given handler.
 // Add new middleware functions here to apply them in order.
 func buildChain(handler http.HandlerFunc) http.HandlerFunc {
-	return middleware.CorsMiddleware(
-		middleware.AuthMiddleware(
+	return middleware.MetricsMiddleware(
+		middleware.CorsMiddleware(
 			middleware.LogRequestMiddleware(
-				middleware.ChannelMiddleware(handler),
+				middleware.AuthMiddleware(
+					middleware.ChannelMiddleware(handler),
+				),
 			),
 		),
 	)
 }
 
+// buildListChain constructs a middleware chain for handlers that aren't bound to a single channel,
+// like the channel list. Requests still require a valid token if users are configured.
+func buildListChain(handler http.HandlerFunc) http.HandlerFunc {
+	return middleware.MetricsMiddleware(
+		middleware.CorsMiddleware(
+			middleware.LogRequestMiddleware(
+				middleware.AuthMiddleware(handler),
+			),
+		),
+	)
+}
+
+// buildAdminChain constructs a middleware chain for the admin endpoints, which require an admin user.
+func buildAdminChain(handler http.HandlerFunc) http.HandlerFunc {
+	return middleware.MetricsMiddleware(
+		middleware.CorsMiddleware(
+			middleware.LogRequestMiddleware(
+				middleware.AuthMiddleware(
+					middleware.AdminMiddleware(handler),
+				),
+			),
+		),
+	)
+}
+
+// buildProbeChain constructs a middleware chain for health checks.
+// They must work without a token and don't need to be logged.
+func buildProbeChain(handler http.HandlerFunc) http.HandlerFunc {
+	return middleware.MetricsMiddleware(
+		middleware.CorsMiddleware(handler),
+	)
+}
+
+// registerStreamRoutes registers the routes serving channels and recordings to players under the given path prefix.
+func registerStreamRoutes(mux *http.ServeMux, cfg config.Config, prefix string) {
+	mux.HandleFunc("GET "+prefix+"/stream/{groupId}/{channelId}/manifest.mpd", buildChain(handlers.DashManifestHandler))
+	mux.HandleFunc("GET "+prefix+"/stream/{groupId}/{channelId}/master.m3u8", buildChain(handlers.HlsMasterPlaylistHandler))
+	mux.HandleFunc("GET "+prefix+"/stream/{groupId}/{channelId}/{qualityId}/playlist.m3u8", buildChain(handlers.HlsMediaPlaylistHandler))
+	mux.HandleFunc("POST "+prefix+"/stream/{groupId}/{channelId}/license", buildChain(handlers.LicenseHandler))
+	mux.HandleFunc("OPTIONS "+prefix+"/stream/{groupId}/{channelId}/license", buildChain(handlers.LicenseHandler))
+	mux.HandleFunc("GET "+prefix+"/stream/{groupId}/{channelId}/clearkey", buildChain(handlers.ClearKeyHandler))
+	mux.HandleFunc("POST "+prefix+"/stream/{groupId}/{channelId}/clearkey", buildChain(handlers.ClearKeyHandler))
+	mux.HandleFunc("OPTIONS "+prefix+"/stream/{groupId}/{channelId}/clearkey", buildChain(handlers.ClearKeyHandler))
+	mux.HandleFunc("GET "+prefix+"/stream/{groupId}/{channelId}/{qualityId}/init.mp4", buildChain(handlers.InitHandler))
+	mux.HandleFunc("GET "+prefix+"/stream/{groupId}/{channelId}/{qualityId}/{time}/{rest...}", buildChain(handlers.SegmentHandler))
+
+	if cfg.RecordingsDir != "" {
+		mux.HandleFunc("GET "+prefix+"/stream/"+config.RecordingsGroup+"/{recordingId}/manifest.mpd", buildListChain(handlers.RecordingManifestHandler))
+		mux.HandleFunc("GET "+prefix+"/stream/"+config.RecordingsGroup+"/{recordingId}/{qualityId}/init.mp4", buildListChain(handlers.RecordingInitHandler))
+		mux.HandleFunc("GET "+prefix+"/stream/"+config.RecordingsGroup+"/{recordingId}/{qualityId}/{time}/{rest...}", buildListChain(handlers.RecordingSegmentHandler))
+	}
+}
+
 // Start initializes and starts the HTTP server.
 // It sets up the request multiplexer with the appropriate routes and middleware.
 // The server listens on the configured bind address and port.
@@ -36,38 +93,86 @@ func buildChain(handler http.HandlerFunc) http.HandlerFunc {
 // The server will block until terminated, allowing for graceful shutdown.
 // The function also checks if any users are configured and sets up the routes accordingly.
 // If no users are configured, the routes will not require authentication.
+// If URL signing is configured, the stream routes are also served under the prefix of signed URLs.
 func Start() {
 	cfg := config.Get()
 
 	mux := http.NewServeMux()
 
+	// with users configured, every route is prefixed with the user's token
+	var prefix string
 	if len(cfg.Users) > 0 {
-		mux.HandleFunc("GET /{token}/stream/{groupId}/{channelId}/manifest.mpd", buildChain(handlers.DashManifestHandler))
-		mux.HandleFunc("GET /{token}/stream/{groupId}/{channelId}/{qualityId}/init.mp4", buildChain(handlers.InitHandler))
-		mux.HandleFunc("GET /{token}/stream/{groupId}/{channelId}/{qualityId}/{time}/{rest...}", buildChain(handlers.SegmentHandler))
-	} else {
-		mux.HandleFunc("GET /stream/{groupId}/{channelId}/manifest.mpd", buildChain(handlers.DashManifestHandler))
-		mux.HandleFunc("GET /stream/{groupId}/{channelId}/{qualityId}/init.mp4", buildChain(handlers.InitHandler))
-		mux.HandleFunc("GET /stream/{groupId}/{channelId}/{qualityId}/{time}/{rest...}", buildChain(handlers.SegmentHandler))
+		prefix = "/{token}"
+	}
+
+	// health checks are never prefixed, as orchestrators don't know any tokens
+	mux.HandleFunc("GET /healthz", buildProbeChain(handlers.HealthHandler))
+	mux.HandleFunc("GET /readyz", buildProbeChain(handlers.ReadyHandler))
+	mux.HandleFunc("GET "+prefix+"/channels.json", buildListChain(handlers.ChannelListHandler))
+	mux.HandleFunc("GET "+prefix+"/playlist.m3u", buildListChain(handlers.M3uPlaylistHandler))
+	mux.HandleFunc("GET "+prefix+"/metrics", buildListChain(handlers.MetricsHandler))
+	registerStreamRoutes(mux, cfg, prefix)
+
+	// recordings are served in place of a channel group, so their URLs look like the ones of live channels
+	if cfg.RecordingsDir != "" {
+		mux.HandleFunc("GET "+prefix+"/recordings", buildAdminChain(handlers.RecordingListHandler))
+		mux.HandleFunc("POST "+prefix+"/recordings", buildAdminChain(handlers.RecordingCreateHandler))
+		mux.HandleFunc("GET "+prefix+"/recordings/{recordingId}", buildAdminChain(handlers.RecordingHandler))
+		mux.HandleFunc("POST "+prefix+"/recordings/{recordingId}/stop", buildAdminChain(handlers.RecordingStopHandler))
+		mux.HandleFunc("DELETE "+prefix+"/recordings/{recordingId}", buildAdminChain(handlers.RecordingDeleteHandler))
+	}
+
+	// signed URLs can only be issued with a token, not with another signed URL
+	if cfg.UrlSigningKey != "" {
+		mux.HandleFunc("GET "+prefix+"/stream/{groupId}/{channelId}/sign", buildChain(handlers.SignUrlHandler))
+		if cfg.RecordingsDir != "" {
+			mux.HandleFunc("GET "+prefix+"/stream/"+config.RecordingsGroup+"/{recordingId}/sign", buildListChain(handlers.SignUrlHandler))
+		}
 	}
 
 	if cfg.HideNotFound {
 		mux.HandleFunc("/", handlers.NotFoundHandler)
 	}
 
+	// signed URLs get their own mux, as their patterns would conflict with the ones starting with a token
+	var handler http.Handler = mux
+	if cfg.UrlSigningKey != "" {
+		signedMux := http.NewServeMux()
+		registerStreamRoutes(signedMux, cfg, "/"+config.SignedUrlPrefix+"/{user}/{expires}/{signature}")
+		if cfg.HideNotFound {
+			signedMux.HandleFunc("/", handlers.NotFoundHandler)
+		}
+
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/"+config.SignedUrlPrefix+"/") {
+				signedMux.ServeHTTP(w, r)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	middleware.InitLogger(ctx)
 	defer middleware.ShutdownLogger()
 
+	// recordings interrupted by a restart are resumed, and are interrupted again on shutdown.
+	// Scheduled recordings are started by the scheduler as they become due
+	if cfg.RecordingsDir != "" {
+		recorder.Resume(ctx)
+		defer recorder.Wait()
+		go recorder.RunScheduler(ctx)
+	}
+
 	var servers []*http.Server
 
 	if cfg.HttpPort != 0 {
 		addr := net.JoinHostPort(cfg.BindAddr, strconv.Itoa(int(cfg.HttpPort)))
 		srv := &http.Server{
 			Addr:    addr,
-			Handler: mux,
+			Handler: handler,
 		}
 		servers = append(servers, srv)
 		go func() {
@@ -84,7 +189,7 @@ func Start() {
 		addr := net.JoinHostPort(cfg.BindAddr, strconv.Itoa(int(cfg.HttpsPort)))
 		srv := &http.Server{
 			Addr:    addr,
-			Handler: mux,
+			Handler: handler,
 		}
 		servers = append(servers, srv)
 		go func(srv *http.Server) {
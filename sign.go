@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+)
+
+// runSign implements the sign subcommand, which prints a signed manifest URL of a channel of the config,
+// like the sign endpoint of the server does. Nothing is stored, so the URL can be signed on any machine with the same config.
+func runSign(args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "Path to the configuration file")
+	username := flags.String("user", "", "Username to sign the URL for")
+	channelPath := flags.String("channel", "", "Channel to sign the URL for as group/channel (or recordings/<recording id>)")
+	ttl := flags.Duration("ttl", 0, "Duration the URL stays valid for, defaults to signed_url_ttl of the config")
+	baseUrl := flags.String("base-url", "http://localhost:8080", "URL the server is reachable at")
+	format := flags.String("format", "", "Manifest format to link, mpd or m3u8. Defaults to the channel's destination type, mpd if it serves both")
+	flags.Parse(args)
+
+	group, channelId, found := strings.Cut(*channelPath, "/")
+	if !found || *username == "" {
+		flags.Usage()
+		return errors.New("-user and -channel (as group/channel) are required")
+	}
+	if *ttl < 0 {
+		return errors.New("-ttl must be greater than 0")
+	}
+
+	if err := config.LoadConfig(*configPath); err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	cfg := config.Get()
+	if cfg.UrlSigningKey == "" {
+		return errors.New("url_signing_key isn't set in the config")
+	}
+
+	if *ttl == 0 {
+		*ttl = utils.SignedUrlTTL()
+	}
+	if maxTTL := utils.MaxSignedUrlTTL(); *ttl > maxTTL {
+		return fmt.Errorf("-ttl exceeds signed_url_max_ttl of %s", maxTTL)
+	}
+
+	var userFound bool
+	for _, user := range cfg.Users {
+		userFound = userFound || user.Username == *username
+	}
+	if !userFound {
+		return fmt.Errorf("user %s not found", *username)
+	}
+
+	manifest := "manifest.mpd"
+	if group != config.RecordingsGroup {
+		channel, ok := cfg.GetChannel(group, channelId)
+		if !ok {
+			return fmt.Errorf("channel %s not found", *channelPath)
+		}
+
+		switch {
+		case *format != "" && *format != config.DestinationMpd && *format != config.DestinationM3u8:
+			return fmt.Errorf("invalid -format %q, use mpd or m3u8", *format)
+		case *format != "" && !channel.ServesDestination(*format):
+			return fmt.Errorf("channel %s isn't served as %s", *channelPath, *format)
+		case *format == config.DestinationM3u8, !channel.ServesDestination(config.DestinationMpd):
+			manifest = "master.m3u8"
+		}
+	}
+
+	expires := time.Now().Add(*ttl)
+	fmt.Printf("%s%s/stream/%s/%s/%s\n",
+		strings.TrimSuffix(*baseUrl, "/"),
+		utils.SignStreamPath(cfg.UrlSigningKey, *username, group+"/"+channelId, expires),
+		url.PathEscape(group),
+		url.PathEscape(channelId),
+		manifest,
+	)
+	return nil
+}
@@ -0,0 +1,69 @@
+package transformers
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/models"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestSmoothToDashManifestGolden converts the Smooth manifests in testdata and compares the results with their golden MPDs.
+// Run the tests with -update after intended changes to the output and review the diff of the golden files.
+func TestSmoothToDashManifestGolden(t *testing.T) {
+	manifestPaths, err := filepath.Glob(filepath.Join("testdata", "*.ismc"))
+	if err != nil {
+		t.Fatalf("Failed to list test manifests: %v", err)
+	}
+
+	for _, manifestPath := range manifestPaths {
+		name := strings.TrimSuffix(filepath.Base(manifestPath), ".ismc")
+		t.Run(name, func(t *testing.T) {
+			file, err := os.Open(manifestPath)
+			if err != nil {
+				t.Fatalf("Failed to open manifest: %v", err)
+			}
+			defer file.Close()
+
+			ismManifest, err := models.NewSmoothStream(file)
+			if err != nil {
+				t.Fatalf("Failed to parse manifest: %v", err)
+			}
+
+			mpd, err := SmoothToDashManifest(ismManifest, false, true, config.Channel{Name: name}, "", "")
+			if err != nil {
+				t.Fatalf("Failed to transform manifest: %v", err)
+			}
+
+			// the publish time and timing source depend on the current time
+			mpd.PublishTime = "2025-01-01T00:00:00Z"
+			mpd.UTCTiming.Value = "2025-01-01T00:00:00Z"
+
+			mpdXML, err := mpd.Encode()
+			if err != nil {
+				t.Fatalf("Failed to encode manifest: %v", err)
+			}
+
+			goldenPath := filepath.Join("testdata", name+".mpd")
+			if *update {
+				if err := os.WriteFile(goldenPath, mpdXML, 0644); err != nil {
+					t.Fatalf("Failed to update golden file: %v", err)
+				}
+			}
+
+			expected, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("Failed to read golden file: %v", err)
+			}
+			if !bytes.Equal(mpdXML, expected) {
+				t.Errorf("MPD doesn't match %s:\n%s", goldenPath, mpdXML)
+			}
+		})
+	}
+}
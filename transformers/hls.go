@@ -0,0 +1,317 @@
+package transformers
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/models"
+)
+
+// HLS version advertised in generated playlists. Version 7 is the first one that
+// allows fragmented MP4 media segments with EXT-X-MAP in regular media playlists.
+const hlsVersion = 7
+
+// mediaSequence holds the media sequence numbers of the chunks of a live stream index's last media playlist.
+type mediaSequence struct {
+	// nominalDuration is the chunk duration used to estimate sequence numbers if the timeline can't be followed.
+	// It is taken from the first timeline seen and kept, so estimates stay consistent across refreshes
+	nominalDuration uint64
+	// times holds the start times of the chunks of the last playlist
+	times []uint64
+	// first is the media sequence number of the chunk at times[0]
+	first uint64
+}
+
+var (
+	// mediaSequences holds the media sequences of live stream indexes by manifest URL and stream index name
+	mediaSequences = make(map[string]*mediaSequence)
+	// mediaSequencesMutex protects mediaSequences
+	mediaSequencesMutex sync.Mutex
+)
+
+// hlsRendition holds a variant stream or an alternative rendition referenced from the master playlist.
+type hlsRendition struct {
+	name       string
+	resolution string
+	language   string
+	channels   int
+	uri        string
+	codecs     string
+	bitrate    uint64
+}
+
+// SmoothToHlsMasterPlaylist converts a SmoothStream manifest to an HLS master playlist.
+// It takes the ISM manifest, a boolean indicating if subtitles are allowed in the output and a query string
+// (like "?profile=vlc") appended to the media playlist URIs, which may be empty.
+// It returns the generated master playlist and any error encountered during the conversion process.
+//
+// Every video quality level becomes a variant stream, while audio and subtitle stream indexes are
+// exposed as alternative renditions. Audio renditions are grouped by codec, so players only ever
+// switch between compatible tracks. If the manifest has no video, every audio quality level becomes a variant stream.
+// Media playlists are referenced relative to the master playlist as "<representation id>/playlist.m3u8".
+func SmoothToHlsMasterPlaylist(ismManifest *models.SmoothStream, allowSubs bool, urlQuery string) ([]byte, error) {
+	var videoVariants []hlsRendition
+	var audioGroupIds []string
+	audioGroups := map[string][]hlsRendition{}
+	var subtitles []hlsRendition
+
+	for _, streamIndex := range ismManifest.StreamIndexes {
+		switch streamIndex.Type {
+		case "video":
+			for _, qualityLevel := range streamIndex.QualityLevels {
+				codecs, err := getCodecString(&streamIndex, &qualityLevel)
+				if err != nil {
+					return nil, err
+				}
+				variant := hlsRendition{
+					uri:     GetRepresentationId(&streamIndex, &qualityLevel) + "/playlist.m3u8" + urlQuery,
+					codecs:  codecs,
+					bitrate: qualityLevel.Bitrate,
+				}
+				if qualityLevel.MaxWidth > 0 && qualityLevel.MaxHeight > 0 {
+					variant.resolution = fmt.Sprintf(",RESOLUTION=%dx%d", qualityLevel.MaxWidth, qualityLevel.MaxHeight)
+				}
+				videoVariants = append(videoVariants, variant)
+			}
+		case "audio":
+			if len(streamIndex.QualityLevels) == 0 {
+				continue
+			}
+
+			// renditions can't express bitrate ladders, so we pick the best quality level of each stream index
+			best := streamIndex.QualityLevels[0]
+			for _, qualityLevel := range streamIndex.QualityLevels[1:] {
+				if qualityLevel.Bitrate > best.Bitrate {
+					best = qualityLevel
+				}
+			}
+
+			codecs, err := getCodecString(&streamIndex, &best)
+			if err != nil {
+				return nil, err
+			}
+
+			groupId := "audio-" + codecs
+			if _, exists := audioGroups[groupId]; !exists {
+				audioGroupIds = append(audioGroupIds, groupId)
+			}
+
+			channels := best.Channels
+			if channels == 0 {
+				channels = 2 // default to stereo
+			}
+
+			audioGroups[groupId] = append(audioGroups[groupId], hlsRendition{
+				name:     GetRepresentationId(&streamIndex, &best),
+				language: streamIndex.Language,
+				channels: channels,
+				uri:      GetRepresentationId(&streamIndex, &best) + "/playlist.m3u8" + urlQuery,
+				codecs:   codecs,
+				bitrate:  best.Bitrate,
+			})
+		case "text":
+			if !allowSubs || len(streamIndex.QualityLevels) == 0 {
+				continue
+			}
+			qualityLevel := streamIndex.QualityLevels[0]
+			subtitles = append(subtitles, hlsRendition{
+				name:     GetRepresentationId(&streamIndex, &qualityLevel),
+				language: streamIndex.Language,
+				uri:      GetRepresentationId(&streamIndex, &qualityLevel) + "/playlist.m3u8" + urlQuery,
+				codecs:   "stpp.ttml.im1t",
+			})
+		}
+	}
+
+	playlist := new(bytes.Buffer)
+	playlist.WriteString("#EXTM3U\n")
+	fmt.Fprintf(playlist, "#EXT-X-VERSION:%d\n", hlsVersion)
+	playlist.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	for _, groupId := range audioGroupIds {
+		for i, rendition := range audioGroups[groupId] {
+			fmt.Fprintf(playlist, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=%q,NAME=%q,", groupId, rendition.name)
+			if rendition.language != "" {
+				fmt.Fprintf(playlist, "LANGUAGE=%q,", rendition.language)
+			}
+			fmt.Fprintf(playlist, "DEFAULT=%s,AUTOSELECT=YES,CHANNELS=\"%d\",URI=%q\n", hlsBool(i == 0), rendition.channels, rendition.uri)
+		}
+	}
+
+	for i, rendition := range subtitles {
+		fmt.Fprintf(playlist, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=%q,", rendition.name)
+		if rendition.language != "" {
+			fmt.Fprintf(playlist, "LANGUAGE=%q,", rendition.language)
+		}
+		fmt.Fprintf(playlist, "DEFAULT=%s,AUTOSELECT=YES,URI=%q\n", hlsBool(i == 0), rendition.uri)
+	}
+
+	// variants must list the codecs of every rendition they refer to, including the subtitles
+	var subtitleAttrs, subtitleCodecs string
+	if len(subtitles) > 0 {
+		subtitleAttrs = ",SUBTITLES=\"subs\""
+		subtitleCodecs = "," + subtitles[0].codecs
+	}
+
+	if len(videoVariants) == 0 {
+		// audio only manifest, every audio rendition becomes a variant stream
+		for _, groupId := range audioGroupIds {
+			for _, rendition := range audioGroups[groupId] {
+				fmt.Fprintf(playlist, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=%q%s\n", rendition.bitrate, rendition.codecs+subtitleCodecs, subtitleAttrs)
+				playlist.WriteString(rendition.uri + "\n")
+			}
+		}
+		return playlist.Bytes(), nil
+	}
+
+	for _, variant := range videoVariants {
+		if len(audioGroupIds) == 0 {
+			fmt.Fprintf(playlist, "#EXT-X-STREAM-INF:BANDWIDTH=%d%s,CODECS=%q%s\n", variant.bitrate, variant.resolution, variant.codecs+subtitleCodecs, subtitleAttrs)
+			playlist.WriteString(variant.uri + "\n")
+			continue
+		}
+
+		for _, groupId := range audioGroupIds {
+			var audioBitrate uint64
+			for _, rendition := range audioGroups[groupId] {
+				audioBitrate = max(audioBitrate, rendition.bitrate)
+			}
+			codecs := variant.codecs + "," + audioGroups[groupId][0].codecs + subtitleCodecs
+
+			fmt.Fprintf(playlist, "#EXT-X-STREAM-INF:BANDWIDTH=%d%s,CODECS=%q,AUDIO=%q%s\n", variant.bitrate+audioBitrate, variant.resolution, codecs, groupId, subtitleAttrs)
+			playlist.WriteString(variant.uri + "\n")
+		}
+	}
+
+	return playlist.Bytes(), nil
+}
+
+// SmoothToHlsMediaPlaylist converts a single quality level of a SmoothStream manifest to an HLS media playlist.
+// It returns the generated media playlist and any error encountered during the conversion process.
+//
+// The media sequence numbers of live channels are tracked per channel and stream index, see getMediaSequence.
+//
+// The playlist points to the same init and segment URLs that are used by the DASH manifest, relative
+// to the media playlist: "init.mp4" is advertised in EXT-X-MAP and each segment is addressed
+// as "<time>/<chunk path>". urlQuery (like "?profile=vlc") is appended to these URLs, it may be empty.
+// Live manifests are served as a sliding window without EXT-X-ENDLIST.
+func SmoothToHlsMediaPlaylist(ismManifest *models.SmoothStream, streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel, channel config.Channel, urlQuery string) ([]byte, error) {
+	chunks := streamIndex.GetChunks()
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("stream index %s has no chunks", streamIndex.Type)
+	}
+
+	timeScale := float64(streamIndex.GetTimeScale(ismManifest.TimeScale))
+
+	var maxDuration uint64
+	for _, chunk := range chunks {
+		maxDuration = max(maxDuration, chunk.Duration)
+	}
+
+	// Smooth chunks are addressed by time, but HLS needs a steadily increasing sequence number.
+	// VOD playlists never slide, so they simply start at zero
+	var mediaSequence uint64
+	if ismManifest.IsLive {
+		mediaSequence = getMediaSequence(channel.Url+"/"+streamIndex.GetNameOrType(), chunks)
+	}
+
+	playlist := new(bytes.Buffer)
+	playlist.WriteString("#EXTM3U\n")
+	fmt.Fprintf(playlist, "#EXT-X-VERSION:%d\n", hlsVersion)
+	fmt.Fprintf(playlist, "#EXT-X-TARGETDURATION:%d\n", int64(math.Ceil(float64(maxDuration)/timeScale)))
+	fmt.Fprintf(playlist, "#EXT-X-MEDIA-SEQUENCE:%d\n", mediaSequence)
+	if !ismManifest.IsLive {
+		playlist.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+	fmt.Fprintf(playlist, "#EXT-X-MAP:URI=%q\n", "init.mp4"+urlQuery)
+
+	for _, chunk := range chunks {
+		fmt.Fprintf(playlist, "#EXTINF:%.3f,\n", float64(chunk.Duration)/timeScale)
+		fmt.Fprintf(playlist, "%d/%s%s\n", chunk.Time, GetChunkPath(streamIndex, qualityLevel, chunk.Time), urlQuery)
+	}
+
+	if !ismManifest.IsLive {
+		playlist.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	return playlist.Bytes(), nil
+}
+
+// hlsBool formats a boolean as an HLS enumerated string.
+func hlsBool(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+// getMediaSequence returns the media sequence number of the first chunk of a live stream index's timeline.
+// The key identifies the stream index across playlist requests.
+//
+// HLS requires a chunk to keep its sequence number across playlist refreshes. Chunk durations vary slightly
+// (e.g. AAC chunks alternating between a bit more and a bit less than 2 seconds), so dividing the time of
+// the first chunk by a duration doesn't give consistent numbers. Instead, chunks are counted: the first chunk is
+// looked up in the timeline of the last playlist, which tells how many chunks dropped out since.
+// If the timelines don't overlap, for example because nobody requested the playlist for a while, the number of
+// missed chunks is estimated with a nominal duration kept fixed per stream index.
+func getMediaSequence(key string, chunks []models.Chunk) uint64 {
+	times := make([]uint64, len(chunks))
+	for i, chunk := range chunks {
+		times[i] = chunk.Time
+	}
+
+	mediaSequencesMutex.Lock()
+	defer mediaSequencesMutex.Unlock()
+
+	sequence, found := mediaSequences[key]
+	if !found {
+		sequence = &mediaSequence{nominalDuration: getNominalDuration(chunks)}
+		sequence.first = times[0] / sequence.nominalDuration
+		mediaSequences[key] = sequence
+	} else {
+		sequence.first = sequence.follow(times)
+	}
+	sequence.times = times
+	return sequence.first
+}
+
+// follow returns the media sequence number of the first chunk of a new timeline of the stream index.
+func (s *mediaSequence) follow(times []uint64) uint64 {
+	// the window slid forward or stayed, so the new first chunk is part of the last timeline
+	if i, found := slices.BinarySearch(s.times, times[0]); found {
+		return s.first + uint64(i)
+	}
+
+	// the window grew backwards, so the last first chunk is part of the new timeline
+	if i, found := slices.BinarySearch(times, s.times[0]); found && uint64(i) <= s.first {
+		return s.first - uint64(i)
+	}
+
+	last := s.times[len(s.times)-1]
+	if times[0] > last {
+		// the timelines don't overlap, so the chunks in between are estimated
+		missed := (times[0] - last + s.nominalDuration/2) / s.nominalDuration
+		return s.first + uint64(len(s.times)-1) + max(missed, 1)
+	}
+
+	// the source restarted its timestamps. Players have to start over anyway, but the sequence must not go backwards
+	return s.first + uint64(len(s.times))
+}
+
+// getNominalDuration returns the most common chunk duration of a timeline, the longer one in case of a tie.
+// It is at least 1, so it can be divided by.
+func getNominalDuration(chunks []models.Chunk) uint64 {
+	counts := make(map[uint64]int)
+	var nominal uint64
+	for _, chunk := range chunks {
+		counts[chunk.Duration]++
+		if counts[chunk.Duration] > counts[nominal] || (counts[chunk.Duration] == counts[nominal] && chunk.Duration > nominal) {
+			nominal = chunk.Duration
+		}
+	}
+	return max(nominal, 1)
+}
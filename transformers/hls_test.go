@@ -0,0 +1,209 @@
+package transformers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/models"
+)
+
+const testSmoothManifest = `<?xml version="1.0" encoding="utf-8"?>
+<SmoothStreamingMedia MajorVersion="2" MinorVersion="2" Duration="60000000" TimeScale="10000000">
+  <StreamIndex Type="video" Name="video" Chunks="3" Url="QualityLevels({bitrate})/Fragments(video={start time})">
+    <QualityLevel Index="0" Bitrate="1300000" FourCC="H264" MaxWidth="1280" MaxHeight="720" CodecPrivateData="00000001674d40209e5281806f60284040405000000300100000064e00000d1f400068fa3f13e0a00000000168ef7520" />
+    <c t="0" d="20000000" />
+    <c d="20000000" />
+    <c d="20000000" />
+  </StreamIndex>
+  <StreamIndex Type="audio" Name="audio_deu" Language="deu" Chunks="3" Url="QualityLevels({bitrate})/Fragments(audio_deu={start time})">
+    <QualityLevel Index="0" Bitrate="128000" FourCC="AACL" SamplingRate="48000" Channels="2" CodecPrivateData="1190" />
+    <c t="0" d="20000000" />
+    <c d="20000000" />
+    <c d="20000000" />
+  </StreamIndex>
+</SmoothStreamingMedia>`
+
+func TestSmoothToHlsMediaPlaylist(t *testing.T) {
+	ismManifest, err := models.NewSmoothStream(strings.NewReader(testSmoothManifest))
+	if err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+
+	streamIndex, err := ismManifest.GetStreamIndexByNameOrType("video")
+	if err != nil {
+		t.Fatalf("Failed to get stream index: %v", err)
+	}
+	qualityLevel, err := streamIndex.GetQualityLevelByIndex(0)
+	if err != nil {
+		t.Fatalf("Failed to get quality level: %v", err)
+	}
+
+	playlist, err := SmoothToHlsMediaPlaylist(ismManifest, streamIndex, qualityLevel, config.Channel{}, "")
+	if err != nil {
+		t.Fatalf("Failed to generate media playlist: %v", err)
+	}
+
+	expected := `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:2.000,
+0/QualityLevels(1300000)/Fragments(video=0)
+#EXTINF:2.000,
+20000000/QualityLevels(1300000)/Fragments(video=20000000)
+#EXTINF:2.000,
+40000000/QualityLevels(1300000)/Fragments(video=40000000)
+#EXT-X-ENDLIST
+`
+	if string(playlist) != expected {
+		t.Fatalf("Unexpected media playlist:\n%s", playlist)
+	}
+}
+
+func TestSmoothToHlsMediaPlaylistStreamTimeScale(t *testing.T) {
+	ismManifest := &models.SmoothStream{
+		TimeScale: 10000000,
+		StreamIndexes: []models.StreamIndex{{
+			Type:          "audio",
+			Name:          "audio_deu",
+			TimeScale:     48000,
+			Url:           "QualityLevels({bitrate})/Fragments(audio_deu={start time})",
+			QualityLevels: []models.QualityLevel{{Index: 0, Bitrate: 128000}},
+			ChunkInfos:    []models.ChunkInfos{{StartTime: 96000, Duration: 96000, Repeat: 2}},
+		}},
+	}
+	streamIndex := &ismManifest.StreamIndexes[0]
+
+	playlist, err := SmoothToHlsMediaPlaylist(ismManifest, streamIndex, &streamIndex.QualityLevels[0], config.Channel{}, "")
+	if err != nil {
+		t.Fatalf("Failed to generate media playlist: %v", err)
+	}
+
+	// durations are given in the time scale of the stream index, not the one of the manifest
+	for _, line := range []string{"#EXT-X-TARGETDURATION:2", "#EXTINF:2.000,", "192000/QualityLevels(128000)/Fragments(audio_deu=192000)"} {
+		if !strings.Contains(string(playlist), line+"\n") {
+			t.Errorf("Expected media playlist to contain %q, got:\n%s", line, playlist)
+		}
+	}
+}
+
+func TestSmoothToHlsMasterPlaylist(t *testing.T) {
+	ismManifest, err := models.NewSmoothStream(strings.NewReader(testSmoothManifest))
+	if err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+
+	playlist, err := SmoothToHlsMasterPlaylist(ismManifest, false, "")
+	if err != nil {
+		t.Fatalf("Failed to generate master playlist: %v", err)
+	}
+
+	for _, line := range []string{
+		`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio-mp4a.40.2",NAME="audio_deu_0",LANGUAGE="deu",DEFAULT=YES,AUTOSELECT=YES,CHANNELS="2",URI="audio_deu_0/playlist.m3u8"`,
+		`#EXT-X-STREAM-INF:BANDWIDTH=1428000,RESOLUTION=1280x720,CODECS="avc1.4D4020,mp4a.40.2",AUDIO="audio-mp4a.40.2"`,
+		`video_0/playlist.m3u8`,
+	} {
+		if !strings.Contains(string(playlist), line+"\n") {
+			t.Errorf("Expected master playlist to contain %q, got:\n%s", line, playlist)
+		}
+	}
+}
+
+func TestSmoothToHlsMasterPlaylistSubtitles(t *testing.T) {
+	textStreamIndex := `  <StreamIndex Type="text" Name="textstream_deu" Language="deu" Subtype="SUBT" Chunks="1" Url="QualityLevels({bitrate})/Fragments(textstream_deu={start time})">
+    <QualityLevel Index="0" Bitrate="1000" FourCC="TTML" />
+    <c t="0" d="60000000" />
+  </StreamIndex>
+</SmoothStreamingMedia>`
+	ismManifest, err := models.NewSmoothStream(strings.NewReader(strings.Replace(testSmoothManifest, "</SmoothStreamingMedia>", textStreamIndex, 1)))
+	if err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+
+	playlist, err := SmoothToHlsMasterPlaylist(ismManifest, true, "")
+	if err != nil {
+		t.Fatalf("Failed to generate master playlist: %v", err)
+	}
+
+	// variants referring to the subtitles must list their codec as well
+	for _, line := range []string{
+		`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="textstream_deu_0",LANGUAGE="deu",DEFAULT=YES,AUTOSELECT=YES,URI="textstream_deu_0/playlist.m3u8"`,
+		`#EXT-X-STREAM-INF:BANDWIDTH=1428000,RESOLUTION=1280x720,CODECS="avc1.4D4020,mp4a.40.2,stpp.ttml.im1t",AUDIO="audio-mp4a.40.2",SUBTITLES="subs"`,
+	} {
+		if !strings.Contains(string(playlist), line+"\n") {
+			t.Errorf("Expected master playlist to contain %q, got:\n%s", line, playlist)
+		}
+	}
+
+	// without subtitles, the codec isn't listed
+	playlist, err = SmoothToHlsMasterPlaylist(ismManifest, false, "")
+	if err != nil {
+		t.Fatalf("Failed to generate master playlist: %v", err)
+	}
+	if strings.Contains(string(playlist), "stpp") {
+		t.Errorf("Expected no subtitle codec without subtitles, got:\n%s", playlist)
+	}
+}
+
+func TestSmoothToHlsPlaylistsUrlQuery(t *testing.T) {
+	ismManifest, err := models.NewSmoothStream(strings.NewReader(testSmoothManifest))
+	if err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+
+	master, err := SmoothToHlsMasterPlaylist(ismManifest, false, "?profile=vlc")
+	if err != nil {
+		t.Fatalf("Failed to generate master playlist: %v", err)
+	}
+	for _, line := range []string{`URI="audio_deu_0/playlist.m3u8?profile=vlc"`, "\nvideo_0/playlist.m3u8?profile=vlc\n"} {
+		if !strings.Contains(string(master), line) {
+			t.Errorf("Expected master playlist to contain %q, got:\n%s", line, master)
+		}
+	}
+
+	streamIndex, err := ismManifest.GetStreamIndexByNameOrType("video")
+	if err != nil {
+		t.Fatalf("Failed to get stream index: %v", err)
+	}
+	media, err := SmoothToHlsMediaPlaylist(ismManifest, streamIndex, &streamIndex.QualityLevels[0], config.Channel{}, "?profile=vlc")
+	if err != nil {
+		t.Fatalf("Failed to generate media playlist: %v", err)
+	}
+	for _, line := range []string{`#EXT-X-MAP:URI="init.mp4?profile=vlc"`, "0/QualityLevels(1300000)/Fragments(video=0)?profile=vlc"} {
+		if !strings.Contains(string(media), line+"\n") {
+			t.Errorf("Expected media playlist to contain %q, got:\n%s", line, media)
+		}
+	}
+}
+
+func TestGetMediaSequence(t *testing.T) {
+	// AAC chunks alternating between slightly more and slightly less than 2 seconds, starting at an absolute time
+	timeline := make([]models.Chunk, 100)
+	chunkTime := uint64(17921526100000000)
+	for i := range timeline {
+		duration := uint64(20053333)
+		if i%2 == 1 {
+			duration = 19946667
+		}
+		timeline[i] = models.Chunk{Time: chunkTime, Duration: duration}
+		chunkTime += duration
+	}
+
+	const key = "http://example.com/Manifest/audio"
+	first := getMediaSequence(key, timeline[0:30])
+
+	// the window slides by a varying number of chunks with every refresh, its first chunk must keep its number
+	for _, start := range []int{1, 2, 4, 5, 7, 10, 11, 30} {
+		if sequence := getMediaSequence(key, timeline[start:start+30]); sequence != first+uint64(start) {
+			t.Fatalf("Expected media sequence %d for window starting at chunk %d, got %d", first+uint64(start), start, sequence)
+		}
+	}
+
+	// nobody requested the playlist for a while, so the chunks in between are estimated
+	if sequence := getMediaSequence(key, timeline[70:100]); sequence != first+70 {
+		t.Fatalf("Expected media sequence %d after a gap, got %d", first+70, sequence)
+	}
+}
@@ -0,0 +1,63 @@
+package transformers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Diniboy1123/manifesto/models"
+	"github.com/Diniboy1123/manifesto/segment"
+	"github.com/Diniboy1123/manifesto/segment/audio"
+	"github.com/Diniboy1123/manifesto/segment/subtitle"
+	"github.com/Diniboy1123/manifesto/segment/video"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+// ErrUnsupportedCodec is returned when no init segment can be generated for a quality level,
+// because its stream type or codec isn't supported.
+var ErrUnsupportedCodec = errors.New("unsupported codec")
+
+// GenerateInitSegment generates an init segment for the given quality level from scratch.
+// The generator is picked based on the stream index type and the FourCC of the quality level,
+// the base segment carries the properties shared by all generators.
+// It returns the generated init segment and the decryption information (if a key was provided).
+//
+// If the codec isn't supported, the returned error wraps ErrUnsupportedCodec.
+func GenerateInitSegment(streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel, baseSegment segment.BaseInitSegment) (*mp4.InitSegment, mp4.DecryptInfo, error) {
+	switch streamIndex.Type {
+	case "video":
+		sampleEntry, err := video.SampleEntryForFourCC(qualityLevel.FourCC)
+		if err != nil {
+			return nil, mp4.DecryptInfo{}, fmt.Errorf("%w: %v", ErrUnsupportedCodec, err)
+		}
+		switch sampleEntry {
+		case "avc1":
+			avcInitSegment := video.AVCInitSegment{BaseInitSegment: baseSegment}
+			return avcInitSegment.Generate()
+		default:
+			hevcInitSegment := video.HEVCInitSegment{BaseInitSegment: baseSegment, SampleEntry: sampleEntry}
+			return hevcInitSegment.Generate()
+		}
+	case "audio":
+		switch strings.ToLower(qualityLevel.FourCC) {
+		case "aacl":
+			aacInitSegment := audio.AACInitSegment{BaseInitSegment: baseSegment}
+			return aacInitSegment.Generate()
+		case "ec-3":
+			de3InitSegment := audio.De3InitSegment{BaseInitSegment: baseSegment}
+			return de3InitSegment.Generate()
+		}
+	case "text":
+		switch strings.ToLower(qualityLevel.FourCC) {
+		case "ttml":
+			stppInitSegment := subtitle.STPPInitSegment{BaseInitSegment: baseSegment}
+			// subtitle decryption isn't supported, so there is no decryptInfo
+			init, err := stppInitSegment.Generate()
+			return init, mp4.DecryptInfo{}, err
+		}
+	default:
+		return nil, mp4.DecryptInfo{}, fmt.Errorf("%w: unsupported stream type %q", ErrUnsupportedCodec, streamIndex.Type)
+	}
+
+	return nil, mp4.DecryptInfo{}, fmt.Errorf("%w: unsupported %s FourCC %q", ErrUnsupportedCodec, streamIndex.Type, qualityLevel.FourCC)
+}
@@ -11,15 +11,17 @@ import (
 	"github.com/Diniboy1123/manifesto/models"
 	"github.com/Diniboy1123/manifesto/segment/video"
 	"github.com/Eyevinn/mp4ff/avc"
+	"github.com/Eyevinn/mp4ff/hevc"
 	"github.com/Eyevinn/mp4ff/mp4"
 	"github.com/unki2aut/go-xsd-types"
 )
 
-// GetSmoothManifest requests the ISM manifest from the given URL and parses it into a SmoothStream object
+// GetSmoothManifest requests the ISM manifest from the given URL and parses it into a SmoothStream object.
+// A cached manifest is reused if it is younger than ttl.
 //
 // If the request fails, it returns an error.
-func GetSmoothManifest(url string) (*models.SmoothStream, error) {
-	content, err := utils.DoRequest("GET", url, nil)
+func GetSmoothManifest(url string, ttl time.Duration) (*models.SmoothStream, error) {
+	content, err := utils.DoRequestWithTTL("GET", url, nil, ttl)
 	if err != nil {
 		return nil, err
 	}
@@ -40,16 +42,20 @@ func GetSmoothManifest(url string) (*models.SmoothStream, error) {
 // The function processes the ISM manifest, extracting relevant information such as adaptation sets, segment templates,
 // and representations. It handles different stream types (video, audio, text) and sets appropriate attributes for each representation.
 // It also manages content protection information, including PlayReady protection data and PSSH data.
+// If the channel has Widevine enabled, the mp4protection and Widevine descriptors are advertised as well.
+// If licenseUrl is not empty, it is advertised as the license server URL of the DRM systems.
+// If clearKeyUrl is not empty, the W3C ClearKey system is advertised with clearKeyUrl as its license server URL.
 // The generated DASH manifest is structured according to the DASH-IF specifications, including necessary attributes such as
 // availability start time, publish time, and period information.
 // The function also sets the broadcast type based on whether the manifest is live or static.
-func SmoothToDashManifest(ismManifest *models.SmoothStream, hasKeys, allowSubs bool, channel config.Channel) (*models.MPD, error) {
+// If the channel has time normalization enabled, the presentation is shifted to start at zero (see normalizeTime).
+func SmoothToDashManifest(ismManifest *models.SmoothStream, hasKeys, allowSubs bool, channel config.Channel, licenseUrl, clearKeyUrl string) (*models.MPD, error) {
 	playreadyProtectionData := ismManifest.GetProtectionHeaderForSystemId(mp4.UUIDPlayReady)
 
-	var psshData string
+	var contentProtections []models.Descriptor
 	var err error
-	if playreadyProtectionData != nil {
-		psshData, err = utils.GeneratePsshData(playreadyProtectionData)
+	if !hasKeys && playreadyProtectionData != nil {
+		contentProtections, err = getContentProtections(ismManifest, playreadyProtectionData, channel.Widevine, licenseUrl, clearKeyUrl)
 		if err != nil {
 			return nil, err
 		}
@@ -58,41 +64,19 @@ func SmoothToDashManifest(ismManifest *models.SmoothStream, hasKeys, allowSubs b
 	var adaptationSets []*models.AdaptationSet
 	// streamindex to segmenttemplate
 	for index, streamIndex := range ismManifest.StreamIndexes {
-		var segmentTimelineSs []models.SegmentTimelineS
-
-		for chunk, info := range streamIndex.ChunkInfos {
-			segment := models.SegmentTimelineS{
-				D: info.Duration,
-			}
-
-			if chunk == 0 {
-				segment.T = info.StartTime
-			}
-
-			segmentTimelineSs = append(segmentTimelineSs, segment)
-		}
-
-		var streamIndexName string
-		if streamIndex.Name != "" {
-			streamIndexName = streamIndex.Name
-		} else {
-			streamIndexName = streamIndex.Type
-		}
-
 		segmentTemplate := &models.SegmentTemplate{
-			Timescale:       ismManifest.TimeScale,
+			Timescale:       streamIndex.GetTimeScale(ismManifest.TimeScale),
 			Media:           "$RepresentationID$/$Time$/" + convertSmoothToMpdTag(streamIndex.Url),
 			Initialization:  "$RepresentationID$/init.mp4",
-			SegmentTimeline: &models.SegmentTimeline{S: segmentTimelineSs},
+			SegmentTimeline: &models.SegmentTimeline{S: getSegmentTimeline(streamIndex.GetChunks())},
 		}
 
 		// qualityLevel to representation
 		var representations []*models.Representation
 		audioChannels := 2 // default to stereo
 		for _, qualityLevel := range streamIndex.QualityLevels {
-			id := fmt.Sprintf("%s_%d", streamIndexName, qualityLevel.Index)
 			representation := models.Representation{
-				ID:        id,
+				ID:        GetRepresentationId(&streamIndex, &qualityLevel),
 				Bandwidth: qualityLevel.Bitrate,
 			}
 
@@ -101,22 +85,6 @@ func SmoothToDashManifest(ismManifest *models.SmoothStream, hasKeys, allowSubs b
 				// video has width, height and scantype
 				representation.Width = qualityLevel.MaxWidth
 				representation.Height = qualityLevel.MaxHeight
-
-				if qualityLevel.CodecPrivateData == "" {
-					return nil, fmt.Errorf("CodecPrivateData is empty for quality level %d", qualityLevel.Index)
-				}
-
-				spsNALUs, _, err := video.CodecPrivateDataToSPSPPS(qualityLevel.CodecPrivateData)
-				if err != nil {
-					return nil, fmt.Errorf("failed to parse CodecPrivateData for quality level %d: %w", qualityLevel.Index, err)
-				}
-
-				sps, err := avc.ParseSPSNALUnit(spsNALUs[0], false)
-				if err != nil {
-					return nil, err
-				}
-
-				representation.Codecs = avc.CodecString("avc1", sps)
 				// hardcoded for now
 				representation.ScanType = "progressive"
 			case "audio":
@@ -127,15 +95,11 @@ func SmoothToDashManifest(ismManifest *models.SmoothStream, hasKeys, allowSubs b
 				}
 
 				representation.AudioSamplingRate = strconv.FormatInt(qualityLevel.SamplingRate, 10)
-				switch qualityLevel.FourCC {
-				case "EC-3":
-					representation.Codecs = "ec-3"
-				default:
-					representation.Codecs = "mp4a.40.2"
-				}
-			case "text":
-				// TODO: don't hardcode
-				representation.Codecs = "stpp"
+			}
+
+			representation.Codecs, err = getCodecString(&streamIndex, &qualityLevel)
+			if err != nil {
+				return nil, err
 			}
 
 			representations = append(representations, &representation)
@@ -157,43 +121,13 @@ func SmoothToDashManifest(ismManifest *models.SmoothStream, hasKeys, allowSubs b
 
 		switch streamIndex.Type {
 		case "video":
-			if !hasKeys && playreadyProtectionData != nil {
-				adaptationSet.ContentProtections = []models.Descriptor{
-					{
-						SchemeIDURI: "urn:uuid:" + strings.ToLower(playreadyProtectionData.SystemID),
-						Value:       "MSPR 2.0",
-						Pro: &models.Pro{
-							XMLNS: "urn:microsoft:playready",
-							Data:  playreadyProtectionData.CustomData,
-						},
-						Pssh: &models.Pssh{
-							XMLNS: "urn:mpeg:cenc:2013",
-							Data:  psshData,
-						},
-					},
-				}
-			}
+			adaptationSet.ContentProtections = contentProtections
 		case "audio":
 			adaptationSet.AudioChannelConfiguration = &models.AudioChannelConfiguration{
 				SchemeIdUri: "urn:mpeg:dash:23003:3:audio_channel_configuration:2011",
 				Value:       fmt.Sprint(audioChannels),
 			}
-			if !hasKeys && playreadyProtectionData != nil {
-				adaptationSet.ContentProtections = []models.Descriptor{
-					{
-						SchemeIDURI: "urn:uuid:" + strings.ToLower(playreadyProtectionData.SystemID),
-						Value:       "MSPR 2.0",
-						Pro: &models.Pro{
-							XMLNS: "urn:microsoft:playready",
-							Data:  playreadyProtectionData.CustomData,
-						},
-						Pssh: &models.Pssh{
-							XMLNS: "urn:mpeg:cenc:2013",
-							Data:  psshData,
-						},
-					},
-				}
-			}
+			adaptationSet.ContentProtections = contentProtections
 		case "text":
 			if !allowSubs {
 				continue
@@ -236,7 +170,7 @@ func SmoothToDashManifest(ismManifest *models.SmoothStream, hasKeys, allowSubs b
 	}
 
 	if !ismManifest.IsLive && ismManifest.Duration > 0 {
-		dashManifest.MediaPresentationDuration = &xsd.Duration{Seconds: int64(ismManifest.Duration / 10000000)}
+		dashManifest.MediaPresentationDuration = &xsd.Duration{Seconds: int64(ismManifest.Duration / ismManifest.TimeScale)}
 	}
 
 	if ismManifest.IsLive {
@@ -249,7 +183,7 @@ func SmoothToDashManifest(ismManifest *models.SmoothStream, hasKeys, allowSubs b
 		}
 
 		if ismManifest.DVRWindowLength > 0 {
-			dashManifest.TimeShiftBufferDepth = &xsd.Duration{Seconds: ismManifest.DVRWindowLength / 10000000}
+			dashManifest.TimeShiftBufferDepth = &xsd.Duration{Seconds: ismManifest.DVRWindowLength / int64(ismManifest.TimeScale)}
 		}
 	}
 
@@ -258,9 +192,228 @@ func SmoothToDashManifest(ismManifest *models.SmoothStream, hasKeys, allowSubs b
 		dashManifest.XMLNSCommonEncryption = "urn:mpeg:cenc:2013"
 	}
 
+	// some players can't handle timelines starting decades after the availability start time
+	if channel.NormalizeTime {
+		normalizeTime(dashManifest, ismManifest, channel, time.Now())
+	}
+
 	return dashManifest, nil
 }
 
+// getSegmentTimeline converts the resolved chunks of a stream index to SegmentTimeline entries.
+// Consecutive chunks of the same duration are run-length encoded with the repeat count, and
+// the start time is only given for the first chunk and after gaps in the timeline.
+func getSegmentTimeline(chunks []models.Chunk) []models.SegmentTimelineS {
+	var segmentTimelineSs []models.SegmentTimelineS
+
+	var nextTime uint64
+	for i, chunk := range chunks {
+		last := len(segmentTimelineSs) - 1
+		if i > 0 && chunk.Time == nextTime && chunk.Duration == segmentTimelineSs[last].D {
+			segmentTimelineSs[last].R++
+		} else {
+			segment := models.SegmentTimelineS{
+				D: chunk.Duration,
+			}
+			if i == 0 || chunk.Time != nextTime {
+				segment.T = chunk.Time
+			}
+			segmentTimelineSs = append(segmentTimelineSs, segment)
+		}
+
+		nextTime = chunk.Time + chunk.Duration
+	}
+
+	return segmentTimelineSs
+}
+
+// getContentProtections builds the ContentProtection descriptors advertised for encrypted adaptation sets.
+// The PlayReady descriptor carries the original PlayReady header and a PSSH box generated from it.
+// If licenseUrl is not empty, it is added to the PlayReady descriptor as mspr:la_url and dashif:laurl.
+// It points to the PlayReady license server of the channel, so it isn't advertised for Widevine.
+//
+// If widevine is true or clearKeyUrl is not empty, the descriptors are preceded by an mp4protection descriptor
+// and followed by a Widevine and/or a ClearKey descriptor, whose PSSH is synthesized from the key IDs of the PlayReady header.
+// The default_KID is only advertised if the PlayReady header lists a single key ID, as we can't tell
+// which key belongs to which adaptation set otherwise.
+func getContentProtections(ismManifest *models.SmoothStream, playreadyProtectionData *models.SmoothProtectionHeader, widevine bool, licenseUrl, clearKeyUrl string) ([]models.Descriptor, error) {
+	psshData, err := utils.GeneratePsshData(playreadyProtectionData)
+	if err != nil {
+		return nil, err
+	}
+
+	playready := models.Descriptor{
+		SchemeIDURI: "urn:uuid:" + strings.ToLower(playreadyProtectionData.SystemID),
+		Value:       "MSPR 2.0",
+		Pro: &models.Pro{
+			XMLNS: "urn:microsoft:playready",
+			Data:  playreadyProtectionData.CustomData,
+		},
+		Pssh: &models.Pssh{
+			XMLNS: "urn:mpeg:cenc:2013",
+			Data:  psshData,
+		},
+	}
+
+	if licenseUrl != "" {
+		playready.LaUrl = &models.LaUrl{Data: licenseUrl}
+		playready.DashIfLaUrl = newDashIfLaUrl(licenseUrl)
+	}
+
+	if !widevine && clearKeyUrl == "" {
+		return []models.Descriptor{playready}, nil
+	}
+
+	keyIds, _, err := utils.ExtractPRKeyIds(ismManifest.Protection)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyIds) == 0 {
+		return nil, fmt.Errorf("no PlayReady key ID found")
+	}
+
+	mp4Protection := models.Descriptor{
+		SchemeIDURI: "urn:mpeg:dash:mp4protection:2011",
+		Value:       "cenc",
+	}
+	if len(keyIds) == 1 {
+		mp4Protection.DefaultKID = mp4.UUID(keyIds[0]).String()
+	}
+
+	contentProtections := []models.Descriptor{mp4Protection, playready}
+
+	if widevine {
+		widevinePsshData, err := utils.GenerateWidevinePsshData(keyIds)
+		if err != nil {
+			return nil, err
+		}
+
+		contentProtection := models.Descriptor{
+			SchemeIDURI: "urn:uuid:" + mp4.UUIDWidevine,
+			Value:       "Widevine",
+			Pssh: &models.Pssh{
+				XMLNS: "urn:mpeg:cenc:2013",
+				Data:  widevinePsshData,
+			},
+		}
+		contentProtections = append(contentProtections, contentProtection)
+	}
+
+	if clearKeyUrl != "" {
+		commonPsshData, err := utils.GenerateCommonPsshData(keyIds)
+		if err != nil {
+			return nil, err
+		}
+
+		contentProtections = append(contentProtections, models.Descriptor{
+			SchemeIDURI: "urn:uuid:" + utils.UUIDClearKey,
+			Value:       "ClearKey1.0",
+			Pssh: &models.Pssh{
+				XMLNS: "urn:mpeg:cenc:2013",
+				Data:  commonPsshData,
+			},
+			DashIfLaUrl: newDashIfLaUrl(clearKeyUrl),
+		})
+	}
+
+	return contentProtections, nil
+}
+
+// newDashIfLaUrl creates a DASH-IF license server URL element for ContentProtection descriptors.
+func newDashIfLaUrl(url string) *models.DashIfLaUrl {
+	return &models.DashIfLaUrl{
+		XMLNS: "https://dashif.org/CPS",
+		Data:  url,
+	}
+}
+
+// GetRepresentationId returns the ID of the given quality level as used in init and segment URLs.
+// The ID is built from the stream index name (or type if the name is empty) and the quality level index.
+func GetRepresentationId(streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel) string {
+	return fmt.Sprintf("%s_%d", streamIndex.GetNameOrType(), qualityLevel.Index)
+}
+
+// getCodecString returns the RFC 6381 codec string for the given quality level.
+// For video, the SPS is parsed from the CodecPrivateData to determine the profile and level
+// of either the AVC or the HEVC stream, depending on the FourCC of the quality level.
+//
+// If the CodecPrivateData can't be parsed, it returns an error.
+func getCodecString(streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel) (string, error) {
+	switch streamIndex.Type {
+	case "video":
+		if qualityLevel.CodecPrivateData == "" {
+			return "", fmt.Errorf("CodecPrivateData is empty for quality level %d", qualityLevel.Index)
+		}
+
+		sampleEntry, err := video.SampleEntryForFourCC(qualityLevel.FourCC)
+		if err != nil {
+			return "", err
+		}
+
+		if sampleEntry != "avc1" {
+			_, spsNALUs, _, err := video.CodecPrivateDataToVPSSPSPPS(qualityLevel.CodecPrivateData)
+			if err != nil {
+				return "", fmt.Errorf("failed to parse CodecPrivateData for quality level %d: %w", qualityLevel.Index, err)
+			}
+
+			sps, err := hevc.ParseSPSNALUnit(spsNALUs[0])
+			if err != nil {
+				return "", err
+			}
+
+			return hevc.CodecString(sampleEntry, sps), nil
+		}
+
+		spsNALUs, _, err := video.CodecPrivateDataToSPSPPS(qualityLevel.CodecPrivateData)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse CodecPrivateData for quality level %d: %w", qualityLevel.Index, err)
+		}
+
+		sps, err := avc.ParseSPSNALUnit(spsNALUs[0], false)
+		if err != nil {
+			return "", err
+		}
+
+		return avc.CodecString("avc1", sps), nil
+	case "audio":
+		switch qualityLevel.FourCC {
+		case "EC-3":
+			return "ec-3", nil
+		default:
+			return "mp4a.40.2", nil
+		}
+	case "text":
+		// TODO: don't hardcode
+		return "stpp", nil
+	}
+	return "", nil
+}
+
+// GetTrackKeyId returns the key ID the quality level is encrypted with, if it can be told without the PlayReady header.
+// The key ID found in chunkData (see utils.ExtractKeyIdFromSegment) takes precedence over the one configured for the
+// track in the channel's track_key_ids. chunkData may be nil if no chunk was fetched. It returns nil if neither is known.
+func GetTrackKeyId(channel config.Channel, streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel, chunkData []byte) []byte {
+	if keyId := utils.ExtractKeyIdFromSegment(chunkData); keyId != nil {
+		return keyId
+	}
+	return channel.GetTrackKeyId(streamIndex.GetNameOrType(), GetRepresentationId(streamIndex, qualityLevel))
+}
+
+// GetChunkPath returns the path of a chunk relative to the Smooth manifest URL.
+// It fills the bitrate and start time placeholders of the stream index's URL template.
+func GetChunkPath(streamIndex *models.StreamIndex, qualityLevel *models.QualityLevel, time uint64) string {
+	replacer := strings.NewReplacer(
+		"{bitrate}", strconv.FormatUint(qualityLevel.Bitrate, 10),
+		"{start time}", strconv.FormatUint(time, 10),
+	)
+	return replacer.Replace(streamIndex.Url)
+}
+
+// GetChunkUrl builds the upstream URL of a chunk by replacing the last part of the manifest URL with the chunk path.
+func GetChunkUrl(manifestUrl, chunkPath string) string {
+	return manifestUrl[:strings.LastIndex(manifestUrl, "/")+1] + chunkPath
+}
+
 func convertSmoothToMpdTag(path string) string {
 	replacer := strings.NewReplacer(
 		"{bitrate}", "$Bandwidth$",
@@ -0,0 +1,90 @@
+package transformers
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/internal/utils"
+	"github.com/Diniboy1123/manifesto/models"
+	"github.com/Eyevinn/mp4ff/mp4"
+)
+
+const testPlayReadyHeader = "XAMAAAEAAQBSAzwAVwBSAE0ASABFAEEARABFAFIAIAB4AG0AbABuAHMAPQAiAGgAdAB0AHAAOgAvAC8AcwBjAGgAZQBtAGEAcwAuAG0AaQBjAHIAbwBzAG8AZgB0AC4AYwBvAG0ALwBEAFIATQAvADIAMAAwADcALwAwADMALwBQAGwAYQB5AFIAZQBhAGQAeQBIAGUAYQBkAGUAcgAiACAAdgBlAHIAcwBpAG8AbgA9ACIANAAuADAALgAwAC4AMAAiAD4APABEAEEAVABBAD4APABQAFIATwBUAEUAQwBUAEkATgBGAE8APgA8AEsARQBZAEwARQBOAD4AMQA2ADwALwBLAEUAWQBMAEUATgA+ADwAQQBMAEcASQBEAD4AQQBFAFMAQwBUAFIAPAAvAEEATABHAEkARAA+ADwALwBQAFIATwBUAEUAQwBUAEkATgBGAE8APgA8AEsASQBEAD4ANABSAHAAbABiACsAVABiAE4ARQBTADgAdABHAGsATgBGAFcAVABFAEgAQQA9AD0APAAvAEsASQBEAD4APABDAEgARQBDAEsAUwBVAE0APgBLAEwAagAzAFEAegBRAFAALwBOAEEAPQA8AC8AQwBIAEUAQwBLAFMAVQBNAD4APABMAEEAXwBVAFIATAA+AGgAdAB0AHAAcwA6AC8ALwBwAHIAbwBmAGYAaQBjAGkAYQBsAHMAaQB0AGUALgBrAGUAeQBkAGUAbABpAHYAZQByAHkALgBtAGUAZABpAGEAcwBlAHIAdgBpAGMAZQBzAC4AdwBpAG4AZABvAHcAcwAuAG4AZQB0AC8AUABsAGEAeQBSAGUAYQBkAHkALwA8AC8ATABBAF8AVQBSAEwAPgA8AEMAVQBTAFQATwBNAEEAVABUAFIASQBCAFUAVABFAFMAPgA8AEkASQBTAF8ARABSAE0AXwBWAEUAUgBTAEkATwBOAD4AOAAuADEALgAyADMAMAA0AC4AMwAxADwALwBJAEkAUwBfAEQAUgBNAF8AVgBFAFIAUwBJAE8ATgA+ADwALwBDAFUAUwBUAE8ATQBBAFQAVABSAEkAQgBVAFQARQBTAD4APAAvAEQAQQBUAEEAPgA8AC8AVwBSAE0ASABFAEEARABFAFIAPgA="
+
+func TestGetContentProtections(t *testing.T) {
+	ismManifest := &models.SmoothStream{
+		Protection: []models.SmoothProtectionHeader{
+			{SystemID: mp4.UUIDPlayReady, CustomData: testPlayReadyHeader},
+		},
+	}
+	playreadyProtectionData := ismManifest.GetProtectionHeaderForSystemId(mp4.UUIDPlayReady)
+
+	contentProtections, err := getContentProtections(ismManifest, playreadyProtectionData, false, "", "")
+	if err != nil {
+		t.Fatalf("Failed to build content protections: %v", err)
+	}
+	if len(contentProtections) != 1 || contentProtections[0].Value != "MSPR 2.0" {
+		t.Fatalf("Expected only the PlayReady descriptor, got %+v", contentProtections)
+	}
+
+	contentProtections, err = getContentProtections(ismManifest, playreadyProtectionData, true, "http://localhost/license", "http://localhost/clearkey")
+	if err != nil {
+		t.Fatalf("Failed to build content protections: %v", err)
+	}
+
+	expectedSchemes := []string{
+		"urn:mpeg:dash:mp4protection:2011",
+		"urn:uuid:" + mp4.UUIDPlayReady,
+		"urn:uuid:" + mp4.UUIDWidevine,
+		"urn:uuid:" + utils.UUIDClearKey,
+	}
+	if len(contentProtections) != len(expectedSchemes) {
+		t.Fatalf("Expected %d descriptors, got %d", len(expectedSchemes), len(contentProtections))
+	}
+	for i, scheme := range expectedSchemes {
+		if contentProtections[i].SchemeIDURI != scheme {
+			t.Fatalf("Expected descriptor %d to have scheme %s, got %s", i, scheme, contentProtections[i].SchemeIDURI)
+		}
+	}
+
+	if kid := contentProtections[0].DefaultKID; kid != "6f651ae1-dbe4-4434-bcb4-690d1564c41c" {
+		t.Fatalf("Unexpected default_KID %s", kid)
+	}
+	if laUrl := contentProtections[1].LaUrl; laUrl == nil || laUrl.Data != "http://localhost/license" {
+		t.Fatalf("Expected PlayReady la_url to point to the license proxy, got %+v", laUrl)
+	}
+	if laUrl := contentProtections[2].DashIfLaUrl; laUrl != nil {
+		t.Fatalf("Expected no Widevine laurl, as the license proxy only serves PlayReady licenses, got %+v", laUrl)
+	}
+	if laUrl := contentProtections[3].DashIfLaUrl; laUrl == nil || laUrl.Data != "http://localhost/clearkey" {
+		t.Fatalf("Expected ClearKey laurl to point to the key endpoint, got %+v", laUrl)
+	}
+}
+
+func TestGetTrackKeyId(t *testing.T) {
+	channel := config.Channel{TrackKeyIds: map[string]string{
+		"video":     "6f651ae1dbe44434bcb4690d1564c41c",
+		"video_1":   "000102030405060708090a0b0c0d0e0f",
+		"audio_deu": "0f0e0d0c0b0a09080706050403020100",
+	}}
+	video := &models.StreamIndex{Type: "video", Name: "video"}
+	text := &models.StreamIndex{Type: "text", Name: "textstream_deu"}
+
+	tests := []struct {
+		name        string
+		streamIndex *models.StreamIndex
+		index       int
+		want        string
+	}{
+		{"stream index", video, 0, "6f651ae1dbe44434bcb4690d1564c41c"},
+		{"representation", video, 1, "000102030405060708090a0b0c0d0e0f"},
+		{"not configured", text, 0, ""},
+	}
+	for _, tt := range tests {
+		keyId := GetTrackKeyId(channel, tt.streamIndex, &models.QualityLevel{Index: tt.index}, nil)
+		if hex.EncodeToString(keyId) != tt.want {
+			t.Errorf("%s: expected key ID %q, got %x", tt.name, tt.want, keyId)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package transformers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/models"
+)
+
+// maxAnchorDrift is how far the live edge may drift from where the time anchor of a channel expects it
+// before the channel is anchored again, for example after the source restarted its timestamps
+const maxAnchorDrift = time.Minute
+
+// timeAnchor maps the media timeline of a live channel to the wall clock.
+type timeAnchor struct {
+	// mediaTime is the media time the presentation starts at, in the time scale of the manifest
+	mediaTime uint64
+	// timeScale is the time scale of the manifest mediaTime was taken from
+	timeScale uint64
+	// availabilityStartTime is the wall clock time of mediaTime
+	availabilityStartTime time.Time
+}
+
+var (
+	// timeAnchors holds the time anchors of live channels with normalized time by manifest URL.
+	// Anchors must stay the same across manifest updates, otherwise players would jump around
+	timeAnchors = make(map[string]timeAnchor)
+	// timeAnchorsMutex protects timeAnchors
+	timeAnchorsMutex sync.Mutex
+)
+
+// normalizeTime shifts the presentation of a DASH manifest to start at zero instead of the absolute
+// times of the Smooth timeline. Every SegmentTemplate gets a presentationTimeOffset pointing to the same
+// media time in its own time scale, so audio, video and text stay aligned.
+//
+// Live manifests get a real availabilityStartTime derived from the wall clock and the live edge, anchored once per channel
+// to keep it stable across manifest updates. VOD manifests start with their earliest chunk.
+func normalizeTime(dashManifest *models.MPD, ismManifest *models.SmoothStream, channel config.Channel, now time.Time) {
+	startTime, endTime, found := getTimelineBounds(ismManifest)
+	if !found {
+		return
+	}
+
+	if ismManifest.IsLive {
+		anchor := getTimeAnchor(channel.Url, ismManifest.TimeScale, startTime, endTime, now)
+		startTime = anchor.mediaTime
+		dashManifest.AvailabilityStartTime = anchor.availabilityStartTime.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+
+	for _, period := range dashManifest.Period {
+		for _, adaptationSet := range period.AdaptationSets {
+			segmentTemplate := adaptationSet.SegmentTemplate
+			segmentTemplate.PresentationTimeOffset = models.ScaleTime(startTime, ismManifest.TimeScale, segmentTemplate.Timescale)
+		}
+	}
+}
+
+// getTimelineBounds returns the start time of the earliest chunk and the end time of the latest chunk across
+// all stream indexes, in the time scale of the manifest. It returns false if the manifest has no chunks.
+func getTimelineBounds(ismManifest *models.SmoothStream) (uint64, uint64, bool) {
+	var startTime, endTime uint64
+	var found bool
+	for i := range ismManifest.StreamIndexes {
+		streamIndex := &ismManifest.StreamIndexes[i]
+		chunks := streamIndex.GetChunks()
+		if len(chunks) == 0 {
+			continue
+		}
+
+		timeScale := streamIndex.GetTimeScale(ismManifest.TimeScale)
+		firstTime := models.ScaleTime(chunks[0].Time, timeScale, ismManifest.TimeScale)
+		lastChunk := chunks[len(chunks)-1]
+		lastTime := models.ScaleTime(lastChunk.Time+lastChunk.Duration, timeScale, ismManifest.TimeScale)
+
+		if !found || firstTime < startTime {
+			startTime = firstTime
+		}
+		endTime = max(endTime, lastTime)
+		found = true
+	}
+	return startTime, endTime, found
+}
+
+// getTimeAnchor returns the time anchor of a live channel, anchoring it at startTime if it has none yet.
+// The live edge (endTime) is assumed to be available now, so the presentation starts at now minus the length of the timeline.
+//
+// The channel is anchored again if the live edge is before the anchor or drifted too far from where the anchor expects it.
+func getTimeAnchor(manifestUrl string, timeScale, startTime, endTime uint64, now time.Time) timeAnchor {
+	timeAnchorsMutex.Lock()
+	defer timeAnchorsMutex.Unlock()
+
+	anchor, found := timeAnchors[manifestUrl]
+	if found && anchor.timeScale == timeScale && endTime >= anchor.mediaTime {
+		expectedEdge := anchor.availabilityStartTime.Add(ticksToDuration(endTime-anchor.mediaTime, timeScale))
+		if drift := now.Sub(expectedEdge); drift.Abs() <= maxAnchorDrift {
+			return anchor
+		}
+	}
+
+	anchor = timeAnchor{
+		mediaTime:             startTime,
+		timeScale:             timeScale,
+		availabilityStartTime: now.Add(-ticksToDuration(endTime-startTime, timeScale)),
+	}
+	timeAnchors[manifestUrl] = anchor
+	return anchor
+}
+
+// ticksToDuration converts a duration given in the time scale to a time.Duration.
+func ticksToDuration(ticks, timeScale uint64) time.Duration {
+	return time.Duration(models.ScaleTime(ticks, timeScale, uint64(time.Second)))
+}
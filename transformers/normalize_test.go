@@ -0,0 +1,59 @@
+package transformers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/models"
+)
+
+func TestNormalizeTime(t *testing.T) {
+	channel := config.Channel{Url: "http://localhost/normalize/Manifest", NormalizeTime: true}
+	newManifest := func(videoTime, audioTime uint64) *models.SmoothStream {
+		return &models.SmoothStream{
+			TimeScale: 10000000,
+			IsLive:    true,
+			StreamIndexes: []models.StreamIndex{
+				{Type: "video", TimeScale: 90000, ChunkInfos: []models.ChunkInfos{{StartTime: videoTime, Duration: 180000, Repeat: 3}}},
+				{Type: "audio", TimeScale: 48000, ChunkInfos: []models.ChunkInfos{{StartTime: audioTime, Duration: 96000, Repeat: 3}}},
+			},
+		}
+	}
+	newMpd := func() *models.MPD {
+		return &models.MPD{Period: []*models.Period{{AdaptationSets: []*models.AdaptationSet{
+			{SegmentTemplate: &models.SegmentTemplate{Timescale: 90000}},
+			{SegmentTemplate: &models.SegmentTemplate{Timescale: 48000}},
+		}}}}
+	}
+
+	// video runs from 1000s to 1006s, audio from 999s to 1005s
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	mpd := newMpd()
+	normalizeTime(mpd, newManifest(90000000, 47952000), channel, now)
+
+	if pto := mpd.Period[0].AdaptationSets[0].SegmentTemplate.PresentationTimeOffset; pto != 89910000 {
+		t.Fatalf("Expected video to be offset by 999s, got %d", pto)
+	}
+	if pto := mpd.Period[0].AdaptationSets[1].SegmentTemplate.PresentationTimeOffset; pto != 47952000 {
+		t.Fatalf("Expected audio to be offset by 999s, got %d", pto)
+	}
+	// the live edge at 1006s is available now
+	if mpd.AvailabilityStartTime != "2025-01-01T11:59:53.000Z" {
+		t.Fatalf("Unexpected availabilityStartTime %s", mpd.AvailabilityStartTime)
+	}
+
+	// the anchor is kept while the timeline slides, so players don't jump around
+	mpd = newMpd()
+	normalizeTime(mpd, newManifest(90180000, 48048000), channel, now.Add(2100*time.Millisecond))
+	if mpd.AvailabilityStartTime != "2025-01-01T11:59:53.000Z" || mpd.Period[0].AdaptationSets[1].SegmentTemplate.PresentationTimeOffset != 47952000 {
+		t.Fatalf("Expected the anchor to be kept, got %s", mpd.AvailabilityStartTime)
+	}
+
+	// the channel is anchored again if the source restarted its timestamps
+	mpd = newMpd()
+	normalizeTime(mpd, newManifest(0, 0), channel, now.Add(time.Hour))
+	if mpd.AvailabilityStartTime != "2025-01-01T12:59:54.000Z" || mpd.Period[0].AdaptationSets[0].SegmentTemplate.PresentationTimeOffset != 0 {
+		t.Fatalf("Expected the channel to be anchored again, got %s", mpd.AvailabilityStartTime)
+	}
+}
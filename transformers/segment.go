@@ -0,0 +1,50 @@
+package transformers
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Diniboy1123/manifesto/models"
+	"github.com/Diniboy1123/manifesto/segment"
+	"github.com/Diniboy1123/manifesto/segment/audio"
+	"github.com/Diniboy1123/manifesto/segment/subtitle"
+	"github.com/Diniboy1123/manifesto/segment/video"
+)
+
+// ProcessSegment repackages a Smooth chunk of the given stream index into a CMAF segment starting at options.Time.
+// Video and audio chunks are decrypted with options.Key if it is set, options.DecryptInfo must come from GenerateInitSegment then.
+// Subtitle decryption isn't supported, so the decryption options are ignored for text chunks.
+// timeScale is the time scale of the stream index (see models.StreamIndex.GetTimeScale), which options.Time is given in.
+//
+// If options.Duration isn't set, the duration of the chunk is looked up in the timeline of the stream index.
+// Callers knowing the duration from a newer manifest than streamIndex should set it.
+func ProcessSegment(streamIndex *models.StreamIndex, timeScale uint64, chunkData []byte, options segment.ProcessOptions) ([]byte, error) {
+	if options.Duration == 0 {
+		options.Duration, _ = getChunkDuration(streamIndex, options.Time)
+	}
+
+	switch streamIndex.Type {
+	case "video":
+		return video.ProcessVideoSegment(bytes.NewBuffer(chunkData), options)
+	case "audio":
+		return audio.ProcessAudioSegment(bytes.NewBuffer(chunkData), options)
+	case "text":
+		// chunks usually have similar durations, so the first one is a good guess for chunks that dropped out of the timeline
+		if options.Duration == 0 && len(streamIndex.ChunkInfos) > 0 {
+			options.Duration = streamIndex.ChunkInfos[0].Duration
+		}
+		return subtitle.ProcessSubtitleSegment(bytes.NewBuffer(chunkData), uint32(timeScale), options)
+	}
+	return nil, fmt.Errorf("%w: unsupported stream type %q", ErrUnsupportedCodec, streamIndex.Type)
+}
+
+// getChunkDuration returns the duration of the chunk of the stream index starting at chunkTime.
+// It returns false if the chunk isn't part of the timeline (anymore).
+func getChunkDuration(streamIndex *models.StreamIndex, chunkTime uint64) (uint64, bool) {
+	for _, chunk := range streamIndex.GetChunks() {
+		if chunk.Time == chunkTime {
+			return chunk.Duration, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,93 @@
+package transformers
+
+import (
+	"sync"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/models"
+)
+
+var (
+	// timelines holds the timelines accumulated for channels with an extended DVR window.
+	// It maps manifest URLs to the chunks of their stream indexes by stream index name or type
+	timelines = make(map[string]map[string][]models.Chunk)
+	// timelinesMutex protects timelines
+	timelinesMutex sync.Mutex
+)
+
+// ExtendDvrWindow merges the chunks of a live manifest into the timeline accumulated from the earlier manifests
+// of the channel, and replaces the chunks of the manifest with the accumulated ones of the channel's DVR window.
+// The DVR window length of the manifest is raised to cover the accumulated timeline.
+//
+// Timelines are kept in memory and only accumulated while the channel's manifests or segments are requested,
+// so they start over after a restart and have gaps if nobody watched the channel for a while.
+// Nothing is done if the channel has no DVR window configured.
+func ExtendDvrWindow(ismManifest *models.SmoothStream, channel config.Channel) {
+	if !ismManifest.IsLive || channel.DvrWindow <= 0 {
+		return
+	}
+
+	timelinesMutex.Lock()
+	defer timelinesMutex.Unlock()
+
+	previous := timelines[channel.Url]
+	current := make(map[string][]models.Chunk, len(ismManifest.StreamIndexes))
+
+	for i := range ismManifest.StreamIndexes {
+		streamIndex := &ismManifest.StreamIndexes[i]
+		name := streamIndex.GetNameOrType()
+		timeScale := streamIndex.GetTimeScale(ismManifest.TimeScale)
+
+		windowLength := uint64(channel.DvrWindow.Duration().Seconds() * float64(timeScale))
+		chunks := mergeTimeline(previous[name], streamIndex.GetChunks(), windowLength)
+		current[name] = chunks
+
+		streamIndex.ChunkInfos = make([]models.ChunkInfos, len(chunks))
+		for j, chunk := range chunks {
+			streamIndex.ChunkInfos[j] = models.ChunkInfos{StartTime: chunk.Time, Duration: chunk.Duration}
+		}
+		streamIndex.Chunks = len(chunks)
+
+		if len(chunks) > 0 {
+			last := chunks[len(chunks)-1]
+			// the DVR window length is given in the time scale of the manifest
+			length := models.ScaleTime(last.Time+last.Duration-chunks[0].Time, timeScale, ismManifest.TimeScale)
+			ismManifest.DVRWindowLength = max(ismManifest.DVRWindowLength, int64(length))
+		}
+	}
+
+	// stream indexes that disappeared from the manifest are dropped
+	timelines[channel.Url] = current
+}
+
+// mergeTimeline appends the chunks of the latest manifest to the accumulated timeline of a stream index,
+// and drops the chunks that ended more than windowLength before the end of the timeline.
+//
+// Accumulated chunks starting at or after the first chunk of the latest manifest are replaced by the ones of the manifest,
+// so the timeline starts over if the source restarted its timestamps.
+func mergeTimeline(accumulated, latest []models.Chunk, windowLength uint64) []models.Chunk {
+	if len(latest) == 0 {
+		return accumulated
+	}
+
+	cut := 0
+	for cut < len(accumulated) && accumulated[cut].Time < latest[0].Time {
+		cut++
+	}
+
+	chunks := make([]models.Chunk, 0, cut+len(latest))
+	chunks = append(chunks, accumulated[:cut]...)
+	chunks = append(chunks, latest...)
+
+	last := chunks[len(chunks)-1]
+	end := last.Time + last.Duration
+	if end <= windowLength {
+		return chunks
+	}
+
+	first := 0
+	for first < len(chunks)-1 && chunks[first].Time+chunks[first].Duration <= end-windowLength {
+		first++
+	}
+	return chunks[first:]
+}
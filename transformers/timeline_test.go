@@ -0,0 +1,57 @@
+package transformers
+
+import (
+	"testing"
+
+	"github.com/Diniboy1123/manifesto/config"
+	"github.com/Diniboy1123/manifesto/models"
+)
+
+func TestMergeTimeline(t *testing.T) {
+	accumulated := []models.Chunk{{Time: 0, Duration: 10}, {Time: 10, Duration: 10}, {Time: 20, Duration: 10}}
+
+	// the source manifest slid forward by one chunk
+	chunks := mergeTimeline(accumulated, []models.Chunk{{Time: 10, Duration: 10}, {Time: 20, Duration: 10}, {Time: 30, Duration: 10}}, 100)
+	if len(chunks) != 4 || chunks[0].Time != 0 || chunks[3].Time != 30 {
+		t.Fatalf("Expected the accumulated chunks followed by the new one, got %+v", chunks)
+	}
+
+	// chunks older than the window are dropped
+	chunks = mergeTimeline(chunks, []models.Chunk{{Time: 30, Duration: 10}, {Time: 40, Duration: 10}}, 25)
+	if len(chunks) != 3 || chunks[0].Time != 20 {
+		t.Fatalf("Expected the chunks of the last 25 units, got %+v", chunks)
+	}
+
+	// the timeline starts over if the source restarted its timestamps
+	chunks = mergeTimeline(chunks, []models.Chunk{{Time: 5, Duration: 10}}, 100)
+	if len(chunks) != 1 || chunks[0].Time != 5 {
+		t.Fatalf("Expected only the chunks of the restarted source, got %+v", chunks)
+	}
+}
+
+func TestExtendDvrWindow(t *testing.T) {
+	channel := config.Channel{Url: "http://localhost/extend/Manifest", DvrWindow: config.JSONDuration(60e9)}
+	newManifest := func(startTime uint64) *models.SmoothStream {
+		return &models.SmoothStream{
+			TimeScale:       10000000,
+			IsLive:          true,
+			DVRWindowLength: 40000000,
+			StreamIndexes: []models.StreamIndex{{
+				Type:       "video",
+				ChunkInfos: []models.ChunkInfos{{StartTime: startTime, Duration: 20000000}, {Duration: 20000000}},
+			}},
+		}
+	}
+
+	ExtendDvrWindow(newManifest(0), channel)
+	ismManifest := newManifest(40000000)
+	ExtendDvrWindow(ismManifest, channel)
+
+	chunks := ismManifest.StreamIndexes[0].GetChunks()
+	if len(chunks) != 4 || chunks[0].Time != 0 || chunks[3].Time != 60000000 {
+		t.Fatalf("Unexpected chunks %+v", chunks)
+	}
+	if ismManifest.DVRWindowLength != 80000000 {
+		t.Fatalf("Expected the DVR window to cover the accumulated timeline, got %d", ismManifest.DVRWindowLength)
+	}
+}